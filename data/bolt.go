@@ -0,0 +1,956 @@
+package data
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketResources = []byte("resources")
+	bucketQueues    = []byte("queues")
+	bucketHistory   = []byte("history")
+	bucketBookings  = []byte("bookings")
+)
+
+// Bolt is a Manager backed by an embedded bbolt database. Resources, queues and history are each kept
+// in their own bucket, keyed by resource key. Bookings are kept in a bucket keyed by their ID. Every
+// mutation runs in a single transaction.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return createBuckets(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+func createBuckets(tx *bolt.Tx) error {
+	for _, b := range [][]byte{bucketResources, bucketQueues, bucketHistory, bucketBookings} {
+		_, err := tx.CreateBucketIfNotExists(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func boltGetResource(tx *bolt.Tx, key string) (*models.Resource, error) {
+	v := tx.Bucket(bucketResources).Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	r := &models.Resource{}
+	e := json.Unmarshal(v, r)
+	if e != nil {
+		return nil, e
+	}
+	return r, nil
+}
+
+func boltPutResource(tx *bolt.Tx, r *models.Resource) error {
+	v, e := json.Marshal(r)
+	if e != nil {
+		return e
+	}
+	return tx.Bucket(bucketResources).Put([]byte(r.Key()), v)
+}
+
+func boltGetQueue(tx *bolt.Tx, r *models.Resource) (*models.Queue, error) {
+	q := &models.Queue{
+		Resource: r,
+	}
+
+	v := tx.Bucket(bucketQueues).Get([]byte(r.Key()))
+	if v == nil {
+		return q, nil
+	}
+
+	e := json.Unmarshal(v, &q.Reservations)
+	if e != nil {
+		return nil, e
+	}
+	// The resource is implied by the queue so it isn't stored with each reservation
+	for _, res := range q.Reservations {
+		res.Resource = r
+	}
+	return q, nil
+}
+
+func boltPutQueue(tx *bolt.Tx, q *models.Queue) error {
+	key := []byte(q.Resource.Key())
+	if !q.HasReservations() {
+		return tx.Bucket(bucketQueues).Delete(key)
+	}
+
+	v, e := json.Marshal(q.Reservations)
+	if e != nil {
+		return e
+	}
+	return tx.Bucket(bucketQueues).Put(key, v)
+}
+
+// boltRecord appends an event to the history bucket
+func boltRecord(tx *bolt.Tx, ev *models.Event) error {
+	b := tx.Bucket(bucketHistory)
+	seq, e := b.NextSequence()
+	if e != nil {
+		return e
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	v, e := json.Marshal(ev)
+	if e != nil {
+		return e
+	}
+	return b.Put(key, v)
+}
+
+// boltResource returns the resource for name and env, creating it when create is set
+func boltResource(tx *bolt.Tx, name, env string, create bool, t time.Time) (*models.Resource, error) {
+	r, e := boltGetResource(tx, models.ResourceKey(name, env))
+	if e != nil || r != nil || !create {
+		return r, e
+	}
+
+	r = &models.Resource{
+		Name:         name,
+		Env:          env,
+		LastActivity: t,
+	}
+	e = boltPutResource(tx, r)
+	if e != nil {
+		return nil, e
+	}
+	return r, boltRecord(tx, &models.Event{Type: models.ResourceCreated, Name: name, Env: env, Time: t})
+}
+
+func (b *Bolt) Create(name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		r, e := boltResource(tx, name, env, true, now)
+		if e != nil {
+			return e
+		}
+		r.LastActivity = now
+		return boltPutResource(tx, r)
+	})
+}
+
+func (b *Bolt) Reserve(u *models.User, name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		r, e := boltResource(tx, name, env, true, now)
+		if e != nil {
+			return e
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		// check for existing reservation
+		for _, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				return err.AlreadyInQueue
+			}
+		}
+
+		q.Reservations = append(q.Reservations, &models.Reservation{
+			User:     u,
+			Resource: r,
+			Time:     now,
+		})
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.Reserved, User: u, Name: name, Env: env, Time: now})
+	})
+}
+
+func (b *Bolt) ReserveAll(u *models.User, resources []*models.Resource) error {
+	resources, keys := composite(resources)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		queues := []*models.Queue{}
+		for _, r := range resources {
+			existing, e := boltResource(tx, r.Name, r.Env, true, now)
+			if e != nil {
+				return e
+			}
+			q, e := boltGetQueue(tx, existing)
+			if e != nil {
+				return e
+			}
+			if q.Find(u) != -1 {
+				return err.AlreadyInQueue
+			}
+			queues = append(queues, q)
+		}
+
+		for _, q := range queues {
+			q.Reservations = append(q.Reservations, &models.Reservation{
+				User:      u,
+				Resource:  q.Resource,
+				Time:      now,
+				Composite: keys,
+			})
+			e := boltPutQueue(tx, q)
+			if e != nil {
+				return e
+			}
+
+			q.Resource.LastActivity = now
+			e = boltPutResource(tx, q.Resource)
+			if e != nil {
+				return e
+			}
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.ReservedAll, User: u, Resources: resources, Time: now})
+	})
+}
+
+func (b *Bolt) GrantAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		queues := []*models.Queue{}
+		for _, r := range resources {
+			existing, e := boltGetResource(tx, r.Key())
+			if e != nil {
+				return e
+			}
+			if existing == nil {
+				return err.ResourceDoesNotExist
+			}
+			q, e := boltGetQueue(tx, existing)
+			if e != nil {
+				return e
+			}
+			idx := q.Find(u)
+			if idx == -1 {
+				return err.NotInQueue
+			}
+			if idx >= len(q.Holders()) || !q.Reservations[idx].Pending() {
+				return err.NotHolder
+			}
+			queues = append(queues, q)
+		}
+
+		now := time.Now()
+		for _, q := range queues {
+			res := q.Reservations[q.Find(u)]
+			res.Composite = nil
+			res.Time = now
+			e := boltPutQueue(tx, q)
+			if e != nil {
+				return e
+			}
+
+			q.Resource.LastActivity = now
+			e = boltPutResource(tx, q.Resource)
+			if e != nil {
+				return e
+			}
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.GrantedAll, User: u, Resources: resources, Time: now})
+	})
+}
+
+// UpdateResource changes a resource by calling update with a copy of it in the transaction
+func (b *Bolt) UpdateResource(name, env string, update func(r *models.Resource) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		existing, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if existing == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.Name, updated.Env = existing.Name, existing.Env
+		updated.LastActivity = time.Now()
+		e = boltPutResource(tx, &updated)
+		if e != nil {
+			return e
+		}
+
+		// Raising the capacity moves the next users in line into the holders
+		if updated.Holders() > existing.Holders() {
+			q, e := boltGetQueue(tx, &updated)
+			if e != nil {
+				return e
+			}
+			q.Promote(existing.Holders(), updated.LastActivity)
+			e = boltPutQueue(tx, q)
+			if e != nil {
+				return e
+			}
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.ResourceUpdated, Name: name, Env: env, Resource: &updated, Time: updated.LastActivity})
+	})
+}
+
+// UpdateReservation changes the user's reservation for a resource by calling update with a copy of it in the
+// transaction. The time of the reservation is not changed.
+func (b *Bolt) UpdateReservation(u *models.User, name, env string, update func(res *models.Reservation) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		existing := q.Reservations[idx]
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.User = existing.User
+		updated.Resource = r
+		updated.Time = existing.Time
+		q.Reservations[idx] = &updated
+
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+		return boltRecord(tx, &models.Event{Type: models.ReservationUpdated, User: updated.User, Name: name, Env: env, Reservation: &updated, Time: time.Now()})
+	})
+}
+
+func (b *Bolt) GetReservation(u *models.User, name, env string) *models.Reservation {
+	var ret *models.Reservation
+	e := b.db.View(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil || r == nil {
+			return e
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+		for _, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				ret = res
+				break
+			}
+		}
+		return nil
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+	}
+	return ret
+}
+
+// Remove removes a user from a resource's queue.
+// If the removal advances the queue, the new resource holder's reservation will have the time updated
+func (b *Bolt) Remove(u *models.User, name, env string) error {
+	return b.remove(u, name, env, models.Removed)
+}
+
+// Kick removes a user from a resource's queue on behalf of someone else
+func (b *Bolt) Kick(u *models.User, name, env string) error {
+	return b.remove(u, name, env, models.Kicked)
+}
+
+func (b *Bolt) remove(u *models.User, name, env string, t models.EventType) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		length := q.HeldFor(idx, now)
+		held := q.Remove(idx, now)
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		if t == models.Removed && held {
+			t = models.Released
+		}
+		return boltRecord(tx, &models.Event{Type: t, User: u, Name: name, Env: env, Held: length, Time: now})
+	})
+}
+
+// Move moves a user to pos in a resource's queue, where 1 is the front. Users who become holders because of
+// the move will have the time on their reservation updated.
+func (b *Bolt) Move(u *models.User, name, env string, pos int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Move(idx, pos-1, now)
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.Moved, User: u, Name: name, Env: env, Position: pos, Time: now})
+	})
+}
+
+// Swap swaps the places of two users in a resource's queue. Users who become holders because of the swap
+// will have the time on their reservation updated.
+func (b *Bolt) Swap(a, c *models.User, name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		i, j := q.Find(a), q.Find(c)
+		if i == -1 || j == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Swap(i, j, now)
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.Swapped, User: a, Target: c, Name: name, Env: env, Time: now})
+	})
+}
+
+// Handoff hands a resource the user holds over to another user, who takes their place among the holders
+// and starts holding it now. The other user's place in the queue, if they had one, is given up.
+func (b *Bolt) Handoff(u, to *models.User, name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		if idx >= len(q.Holders()) {
+			return err.NotHolder
+		}
+
+		now := time.Now()
+		length := q.HeldFor(idx, now)
+		q.Handoff(idx, to, now)
+		e = boltPutQueue(tx, q)
+		if e != nil {
+			return e
+		}
+
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.HandedOff, User: u, Target: to, Name: name, Env: env, Held: length, Time: now})
+	})
+}
+
+func (b *Bolt) GetPosition(u *models.User, name, env string) (int, error) {
+	pos := 0
+	e := b.db.View(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := boltGetQueue(tx, r)
+		if e != nil {
+			return e
+		}
+		for i, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				pos = i + 1
+				return nil
+			}
+		}
+		return err.NotInQueue
+	})
+	if e != nil {
+		return 0, e
+	}
+	return pos, nil
+}
+
+func (b *Bolt) GetResource(name, env string, create bool) *models.Resource {
+	var r *models.Resource
+	var e error
+	if create {
+		e = b.db.Update(func(tx *bolt.Tx) error {
+			r, e = boltResource(tx, name, env, true, time.Now())
+			return e
+		})
+	} else {
+		e = b.db.View(func(tx *bolt.Tx) error {
+			r, e = boltGetResource(tx, models.ResourceKey(name, env))
+			return e
+		})
+	}
+	if e != nil {
+		log.Errorf("%+v", e)
+		return nil
+	}
+	return r
+}
+
+func (b *Bolt) RemoveResource(name, env string) error {
+	return b.removeResource(name, env, models.ResourceRemoved)
+}
+
+func (b *Bolt) removeResource(name, env string, t models.EventType) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(models.ResourceKey(name, env))
+		if tx.Bucket(bucketResources).Get(key) == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		e := tx.Bucket(bucketQueues).Delete(key)
+		if e != nil {
+			return e
+		}
+		e = tx.Bucket(bucketResources).Delete(key)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: t, Name: name, Env: env, Time: time.Now()})
+	})
+}
+
+func (b *Bolt) RemoveEnv(name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		resources, e := boltResources(tx, func(r *models.Resource) bool {
+			return r.Env == env
+		})
+		if e != nil {
+			return e
+		}
+		if len(resources) == 0 {
+			return err.EnvDoesNotExist
+		}
+
+		for _, r := range resources {
+			key := []byte(r.Key())
+			e := tx.Bucket(bucketQueues).Delete(key)
+			if e != nil {
+				return e
+			}
+			e = tx.Bucket(bucketResources).Delete(key)
+			if e != nil {
+				return e
+			}
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.EnvRemoved, Name: name, Env: env, Time: time.Now()})
+	})
+}
+
+// boltResources returns all resources matching filter, sorted by key
+func boltResources(tx *bolt.Tx, filter func(r *models.Resource) bool) ([]*models.Resource, error) {
+	ret := []*models.Resource{}
+	e := tx.Bucket(bucketResources).ForEach(func(k, v []byte) error {
+		r := &models.Resource{}
+		e := json.Unmarshal(v, r)
+		if e != nil {
+			return e
+		}
+		if filter == nil || filter(r) {
+			ret = append(ret, r)
+		}
+		return nil
+	})
+	return ret, e
+}
+
+func (b *Bolt) GetResources() []*models.Resource {
+	var ret []*models.Resource
+	e := b.db.View(func(tx *bolt.Tx) error {
+		var e error
+		ret, e = boltResources(tx, nil)
+		return e
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (b *Bolt) GetQueues() []*models.Queue {
+	ret := []*models.Queue{}
+	e := b.db.View(func(tx *bolt.Tx) error {
+		resources, e := boltResources(tx, nil)
+		if e != nil {
+			return e
+		}
+		for _, r := range resources {
+			q, e := boltGetQueue(tx, r)
+			if e != nil {
+				return e
+			}
+			ret = append(ret, q)
+		}
+		return nil
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+	}
+	return ret
+}
+
+func (b *Bolt) GetQueueForResource(name, env string) (*models.Queue, error) {
+	var q *models.Queue
+	e := b.db.View(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e = boltGetQueue(tx, r)
+		return e
+	})
+	if e != nil {
+		return nil, e
+	}
+	return q, nil
+}
+
+func (b *Bolt) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	q, e := b.GetQueueForResource(name, env)
+	if e != nil {
+		return nil, e
+	}
+	if !q.HasReservations() {
+		return nil, nil
+	}
+	return q.Reservations[0], nil
+}
+
+func (b *Bolt) GetQueuesForEnv(env string) map[string]*models.Queue {
+	ret := make(map[string]*models.Queue)
+	e := b.db.View(func(tx *bolt.Tx) error {
+		resources, e := boltResources(tx, func(r *models.Resource) bool {
+			return r.Env == env
+		})
+		if e != nil {
+			return e
+		}
+		for _, r := range resources {
+			q, e := boltGetQueue(tx, r)
+			if e != nil {
+				return e
+			}
+			ret[r.Name] = q
+		}
+		return nil
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+	}
+	return ret
+}
+
+func (b *Bolt) GetResourcesForEnv(env string) []*models.Resource {
+	var ret []*models.Resource
+	e := b.db.View(func(tx *bolt.Tx) error {
+		var e error
+		ret, e = boltResources(tx, func(r *models.Resource) bool {
+			return r.Env == env
+		})
+		return e
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (b *Bolt) GetAllUsersInQueues() []*models.User {
+	all := map[string]*models.User{}
+	for _, q := range b.GetQueues() {
+		for _, res := range q.Reservations {
+			all[res.User.ID] = res.User
+		}
+	}
+
+	ret := []*models.User{}
+	for _, u := range all {
+		ret = append(ret, u)
+	}
+	return ret
+}
+
+func (b *Bolt) ClearQueueForResource(name, env string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, e := boltGetResource(tx, models.ResourceKey(name, env))
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		e = tx.Bucket(bucketQueues).Delete([]byte(r.Key()))
+		if e != nil {
+			return e
+		}
+
+		now := time.Now()
+		r.LastActivity = now
+		e = boltPutResource(tx, r)
+		if e != nil {
+			return e
+		}
+
+		return boltRecord(tx, &models.Event{Type: models.Cleared, Name: name, Env: env, Time: now})
+	})
+}
+
+func (b *Bolt) PruneInactiveResources(hours int) error {
+	return pruneInactiveResources(b, hours, func(name, env string) error {
+		return b.removeResource(name, env, models.Pruned)
+	})
+}
+
+// Nuke removes all resources and queues. History is kept.
+func (b *Bolt) Nuke() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketResources, bucketQueues} {
+			e := tx.DeleteBucket(name)
+			if e != nil {
+				return e
+			}
+		}
+		e := createBuckets(tx)
+		if e != nil {
+			return e
+		}
+		return boltRecord(tx, &models.Event{Type: models.Nuked, Time: time.Now()})
+	})
+}
+
+func boltPutBooking(tx *bolt.Tx, bk *models.Booking) error {
+	v, e := json.Marshal(bk)
+	if e != nil {
+		return e
+	}
+	return tx.Bucket(bucketBookings).Put([]byte(bk.ID), v)
+}
+
+func (b *Bolt) AddBooking(bk *models.Booking) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		e := boltPutBooking(tx, bk)
+		if e != nil {
+			return e
+		}
+		c := *bk
+		return boltRecord(tx, &models.Event{Type: models.BookingAdded, User: bk.User, Name: bk.Name, Env: bk.Env, Booking: &c, Time: time.Now()})
+	})
+}
+
+// UpdateBooking replaces the attributes of the booking with the same ID as bk
+func (b *Bolt) UpdateBooking(bk *models.Booking) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketBookings).Get([]byte(bk.ID)) == nil {
+			return err.BookingDoesNotExist
+		}
+		e := boltPutBooking(tx, bk)
+		if e != nil {
+			return e
+		}
+		c := *bk
+		return boltRecord(tx, &models.Event{Type: models.BookingUpdated, User: bk.User, Name: bk.Name, Env: bk.Env, Booking: &c, Time: time.Now()})
+	})
+}
+
+func (b *Bolt) RemoveBooking(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(id)
+		if tx.Bucket(bucketBookings).Get(key) == nil {
+			return err.BookingDoesNotExist
+		}
+		e := tx.Bucket(bucketBookings).Delete(key)
+		if e != nil {
+			return e
+		}
+		return boltRecord(tx, &models.Event{Type: models.BookingRemoved, Booking: &models.Booking{ID: id}, Time: time.Now()})
+	})
+}
+
+// GetBookings returns all bookings, ordered by start
+func (b *Bolt) GetBookings() []*models.Booking {
+	ret := []*models.Booking{}
+	e := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBookings).ForEach(func(k, v []byte) error {
+			bk := &models.Booking{}
+			e := json.Unmarshal(v, bk)
+			if e != nil {
+				return e
+			}
+			ret = append(ret, bk)
+			return nil
+		})
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+	}
+	sortBookings(ret)
+	return ret
+}
+
+func (b *Bolt) GetEvents(since time.Time) []*models.Event {
+	ret := []*models.Event{}
+	e := b.db.View(func(tx *bolt.Tx) error {
+		// Events are stored in the order they happened, so only the newest ones need to be read
+		c := tx.Bucket(bucketHistory).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			ev := &models.Event{}
+			e := json.Unmarshal(v, ev)
+			if e != nil {
+				return e
+			}
+			if ev.Time.Before(since) {
+				break
+			}
+			ret = append(ret, ev)
+		}
+		return nil
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+	}
+	reverseEvents(ret)
+	return ret
+}
@@ -0,0 +1,601 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// stores are the Managers every scenario is run against. open returns a new, empty store.
+var stores = []struct {
+	name string
+	open func(t *testing.T) Manager
+}{
+	{"memory", func(t *testing.T) Manager {
+		return NewMemory()
+	}},
+	{"file", func(t *testing.T) Manager {
+		f, e := NewFile(filepath.Join(tempDir(t), "reservebot.json"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		return f
+	}},
+	{"bolt", func(t *testing.T) Manager {
+		b, e := NewBolt(filepath.Join(tempDir(t), "reservebot.db"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	}},
+	{"sqlite", func(t *testing.T) Manager {
+		s, e := NewSQL(filepath.Join(tempDir(t), "reservebot.sqlite"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}},
+	{"postgres", openPostgres},
+	{"redis", func(t *testing.T) Manager {
+		server, e := miniredis.Run()
+		if e != nil {
+			t.Fatal(e)
+		}
+		t.Cleanup(server.Close)
+		r, e := NewRedis("redis://" + server.Addr())
+		if e != nil {
+			t.Fatal(e)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}},
+}
+
+// scenarios are run against a new store of every kind, so that they all behave the same
+var scenarios = []struct {
+	name string
+	run  func(t *testing.T, m Manager)
+}{
+	{"reserve", func(t *testing.T, m Manager) {
+		check(t, m.Reserve(alice, "web", "qa"))
+		check(t, m.Reserve(bob, "web", "qa"))
+		expect(t, m.Reserve(alice, "web", "qa"), err.AlreadyInQueue)
+		expectQueue(t, m, "web", "qa", "alice,bob")
+
+		if r := m.GetResource("web", "qa", false); r == nil {
+			t.Fatal("resource was not created")
+		}
+		pos, e := m.GetPosition(bob, "web", "qa")
+		check(t, e)
+		if pos != 2 {
+			t.Errorf("position is %d, want 2", pos)
+		}
+		cu, e := m.GetReservationForResource("web", "qa")
+		check(t, e)
+		if cu == nil || cu.User.ID != alice.ID {
+			t.Errorf("holder is %v, want alice", cu)
+		}
+		_, e = m.GetPosition(carol, "web", "qa")
+		expect(t, e, err.NotInQueue)
+	}},
+	{"remove", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol)
+		check(t, m.Remove(bob, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "alice,carol")
+
+		before := time.Now()
+		check(t, m.Remove(alice, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "carol")
+		if res := m.GetReservation(carol, "web", "qa"); res == nil || res.Time.Before(before.Add(-time.Second)) {
+			t.Errorf("new holder's reservation time was not updated")
+		}
+
+		expect(t, m.Remove(alice, "web", "qa"), err.NotInQueue)
+		expect(t, m.Remove(alice, "api", "qa"), err.ResourceDoesNotExist)
+	}},
+	{"kick", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob)
+		check(t, m.Kick(alice, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "bob")
+		expect(t, m.Kick(alice, "web", "qa"), err.NotInQueue)
+	}},
+	{"clear", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob)
+		check(t, m.ClearQueueForResource("web", "qa"))
+		expectQueue(t, m, "web", "qa", "")
+		expect(t, m.ClearQueueForResource("api", "qa"), err.ResourceDoesNotExist)
+	}},
+	{"move", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol)
+		check(t, m.Move(carol, "web", "qa", 2))
+		expectQueue(t, m, "web", "qa", "alice,carol,bob")
+		check(t, m.Move(bob, "web", "qa", 1))
+		expectQueue(t, m, "web", "qa", "bob,alice,carol")
+		expect(t, m.Move(dave, "web", "qa", 1), err.NotInQueue)
+		expect(t, m.Move(bob, "api", "qa", 1), err.ResourceDoesNotExist)
+	}},
+	{"swap", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol)
+		check(t, m.Swap(alice, carol, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "carol,bob,alice")
+		expect(t, m.Swap(alice, dave, "web", "qa"), err.NotInQueue)
+		expect(t, m.Swap(alice, bob, "api", "qa"), err.ResourceDoesNotExist)
+	}},
+	{"handoff", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol)
+		check(t, m.Handoff(alice, carol, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "carol,bob")
+		check(t, m.Handoff(carol, dave, "web", "qa"))
+		expectQueue(t, m, "web", "qa", "dave,bob")
+		expect(t, m.Handoff(bob, alice, "web", "qa"), err.NotHolder)
+		expect(t, m.Handoff(alice, bob, "web", "qa"), err.NotInQueue)
+	}},
+	{"reserve all", func(t *testing.T, m Manager) {
+		web := &models.Resource{Name: "web", Env: "qa"}
+		api := &models.Resource{Name: "api", Env: "qa"}
+		reserveAll(t, m, alice)
+		check(t, m.ReserveAll(bob, []*models.Resource{web, api, web}))
+		expectQueue(t, m, "web", "qa", "alice,bob")
+		expectQueue(t, m, "api", "qa", "bob")
+		res := m.GetReservation(bob, "api", "qa")
+		if res == nil || !res.Pending() || strings.Join(res.Composite, ",") != "qa_api,qa_web" {
+			t.Fatalf("reservation is not pending for both resources: %+v", res)
+		}
+
+		// Nothing changes when the user is already waiting for any of them
+		expect(t, m.ReserveAll(alice, []*models.Resource{api, web}), err.AlreadyInQueue)
+		expectQueue(t, m, "api", "qa", "bob")
+
+		expect(t, m.GrantAll(bob, []*models.Resource{web, api}), err.NotHolder)
+		check(t, m.Remove(alice, "web", "qa"))
+		check(t, m.GrantAll(bob, []*models.Resource{web, api}))
+		for _, r := range []*models.Resource{web, api} {
+			if res := m.GetReservation(bob, r.Name, r.Env); res == nil || res.Pending() {
+				t.Errorf("%s was not granted: %+v", r, res)
+			}
+		}
+		expect(t, m.GrantAll(bob, []*models.Resource{web, api}), err.NotHolder)
+
+		expectQueue(t, StateAt(m, time.Now()), "api", "qa", "bob")
+		if res := StateAt(m, time.Now()).GetReservation(bob, "web", "qa"); res == nil || res.Pending() {
+			t.Errorf("replayed reservation is still pending: %+v", res)
+		}
+	}},
+	{"update resource", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol)
+		check(t, m.UpdateResource("web", "qa", func(r *models.Resource) error {
+			r.Capacity = 2
+			r.Description = "the web frontend"
+			return nil
+		}))
+
+		r := m.GetResource("web", "qa", false)
+		if r.Capacity != 2 || r.Description != "the web frontend" {
+			t.Errorf("resource was not updated: %+v", r)
+		}
+		q, e := m.GetQueueForResource("web", "qa")
+		check(t, e)
+		if holders := ids(q.Holders()); holders != "alice,bob" {
+			t.Errorf("holders are %s, want alice,bob", holders)
+		}
+
+		// Nothing changes when update fails
+		expect(t, m.UpdateResource("web", "qa", func(r *models.Resource) error {
+			r.Capacity = 3
+			return err.NotHolder
+		}), err.NotHolder)
+		if r := m.GetResource("web", "qa", false); r.Capacity != 2 {
+			t.Errorf("capacity changed to %d by a failed update", r.Capacity)
+		}
+
+		expect(t, m.UpdateResource("api", "qa", func(r *models.Resource) error { return nil }), err.ResourceDoesNotExist)
+	}},
+	{"update reservation", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice)
+		res := m.GetReservation(alice, "web", "qa")
+		reserved := res.Time
+		res.Note = "changed"
+		// Changing the returned reservation must not change the stored one
+		if m.GetReservation(alice, "web", "qa").Note != "" {
+			t.Fatal("reservation was changed without UpdateReservation")
+		}
+		check(t, m.UpdateReservation(alice, "web", "qa", func(res *models.Reservation) error {
+			res.Note = "testing the login flow"
+			res.Hold = time.Hour
+			res.Time = time.Now().Add(time.Hour)
+			return nil
+		}))
+
+		res = m.GetReservation(alice, "web", "qa")
+		if res.Note != "testing the login flow" || res.Hold != time.Hour {
+			t.Errorf("reservation was not updated: %+v", res)
+		}
+		if !res.Time.Equal(reserved) {
+			t.Errorf("reservation time changed from %s to %s", reserved, res.Time)
+		}
+
+		expect(t, m.UpdateReservation(bob, "web", "qa", func(res *models.Reservation) error { return nil }), err.NotInQueue)
+	}},
+	{"concurrent updates", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice)
+		users := []*models.User{alice, bob, carol, dave}
+
+		// Every update must see the changes of the ones before it, whichever order they run in
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 2*len(users))
+		for _, u := range users {
+			u := u
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				errs <- m.UpdateResource("web", "qa", func(r *models.Resource) error {
+					r.Watchers = append(append([]*models.User{}, r.Watchers...), u)
+					return nil
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				errs <- m.UpdateReservation(alice, "web", "qa", func(res *models.Reservation) error {
+					res.Extensions++
+					return nil
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			check(t, e)
+		}
+
+		if r := m.GetResource("web", "qa", false); len(r.Watchers) != len(users) {
+			t.Errorf("resource has %d watchers, want %d", len(r.Watchers), len(users))
+		}
+		if res := m.GetReservation(alice, "web", "qa"); res.Extensions != len(users) {
+			t.Errorf("reservation was extended %d times, want %d", res.Extensions, len(users))
+		}
+	}},
+	{"bookings", func(t *testing.T, m Manager) {
+		now := time.Now().Truncate(time.Second)
+		later := &models.Booking{ID: "later", User: alice, Name: "web", Env: "qa", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)}
+		sooner := &models.Booking{ID: "sooner", User: bob, Name: "web", Env: "qa", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}
+		check(t, m.AddBooking(later))
+		check(t, m.AddBooking(sooner))
+		expectBookings(t, m, "sooner,later")
+
+		sooner.Start = now.Add(4 * time.Hour)
+		sooner.End = now.Add(5 * time.Hour)
+		sooner.Every = []time.Weekday{time.Monday}
+		check(t, m.UpdateBooking(sooner))
+		expectBookings(t, m, "later,sooner")
+		b := m.GetBookings()[1]
+		if !b.Start.Equal(sooner.Start) || !b.Recurring() {
+			t.Errorf("booking was not updated: %+v", b)
+		}
+
+		check(t, m.RemoveBooking("later"))
+		expectBookings(t, m, "sooner")
+		expect(t, m.RemoveBooking("later"), err.BookingDoesNotExist)
+		expect(t, m.UpdateBooking(later), err.BookingDoesNotExist)
+	}},
+	{"events", func(t *testing.T, m Manager) {
+		start := time.Now()
+		reserveAll(t, m, alice, bob, carol)
+		time.Sleep(time.Millisecond)
+		middle := time.Now()
+		check(t, m.Remove(carol, "web", "qa"))
+		check(t, m.Remove(alice, "web", "qa"))
+		check(t, m.Kick(bob, "web", "qa"))
+		expect(t, m.Remove(alice, "web", "qa"), err.NotInQueue)
+
+		types := []string{}
+		for _, ev := range m.GetEvents(start) {
+			// Not every store records the implicit creation of a resource
+			if ev.Type != models.ResourceCreated {
+				types = append(types, string(ev.Type))
+			}
+		}
+		want := "Reserved,Reserved,Reserved,Removed,Released,Kicked"
+		if got := strings.Join(types, ","); got != want {
+			t.Errorf("events are %s, want %s", got, want)
+		}
+		for _, ev := range m.GetEvents(start) {
+			// Only the events that end a hold say how long it lasted
+			held := ev.Type == models.Released || ev.Type == models.Kicked
+			if held != (ev.Held > 0) {
+				t.Errorf("%s event says it was held for %s", ev.Type, ev.Held)
+			}
+		}
+		if events := m.GetEvents(middle); len(events) != 3 || events[0].Type != models.Removed {
+			t.Errorf("got %d events since the middle, want the last 3", len(events))
+		}
+		if events := m.GetEvents(time.Now().Add(time.Hour)); len(events) != 0 {
+			t.Errorf("got %d events from the future", len(events))
+		}
+	}},
+	{"replay", func(t *testing.T, m Manager) {
+		reserveAll(t, m, alice, bob, carol, dave)
+		check(t, m.Move(dave, "web", "qa", 2))
+		check(t, m.Swap(bob, carol, "web", "qa"))
+		check(t, m.Handoff(alice, bob, "web", "qa"))
+		check(t, m.Remove(carol, "web", "qa"))
+
+		expectQueue(t, m, "web", "qa", "bob,dave")
+		expectQueue(t, StateAt(m, time.Now()), "web", "qa", "bob,dave")
+	}},
+}
+
+// reopenable are the stores that keep their state on disk. open opens the store at path, which is reused
+// across reopens.
+var reopenable = []struct {
+	name string
+	open func(t *testing.T, path string) (Manager, func() error)
+}{
+	{"file", func(t *testing.T, path string) (Manager, func() error) {
+		f, e := NewFile(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return f, f.Close
+	}},
+	{"bolt", func(t *testing.T, path string) (Manager, func() error) {
+		b, e := NewBolt(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return b, b.Close
+	}},
+	{"sqlite", func(t *testing.T, path string) (Manager, func() error) {
+		s, e := NewSQL(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return s, s.Close
+	}},
+}
+
+// TestReopen checks that the state of each persistent store is the same after it is closed and reopened
+func TestReopen(t *testing.T) {
+	// Small enough that the file store moves its journal aside a few times
+	defer func(n int) { snapshotAfter = n }(snapshotAfter)
+	snapshotAfter = 4
+
+	for _, s := range reopenable {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "reservebot")
+			m, close := s.open(t, path)
+			fill(t, m)
+			want := dump(m)
+			check(t, close())
+
+			m, close = s.open(t, path)
+			defer close()
+			if got := dump(m); got != want {
+				t.Errorf("state after reopening is\n%s\nwant\n%s", got, want)
+			}
+
+			// The reopened store carries on where it left off
+			check(t, m.Remove(carol, "web", "qa"))
+			expectQueue(t, m, "web", "qa", "bob,dave")
+		})
+	}
+}
+
+// TestTornJournal checks that an event cut short by a crash is dropped, while a corrupt event before the end
+// of the journal is refused
+func TestTornJournal(t *testing.T) {
+	path := filepath.Join(tempDir(t), "reservebot.json")
+	f, e := NewFile(path)
+	check(t, e)
+	reserveAll(t, f, alice, bob)
+	check(t, f.Close())
+
+	journal, e := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0600)
+	check(t, e)
+	_, e = journal.WriteString(`{"type":"Reserved","time":"2021-`)
+	check(t, e)
+	check(t, journal.Close())
+
+	f, e = NewFile(path)
+	check(t, e)
+	expectQueue(t, f, "web", "qa", "alice,bob")
+	check(t, f.Reserve(carol, "web", "qa"))
+	check(t, f.Close())
+
+	f, e = NewFile(path)
+	check(t, e)
+	expectQueue(t, f, "web", "qa", "alice,bob,carol")
+	check(t, f.Close())
+
+	b, e := ioutil.ReadFile(path + ".journal")
+	check(t, e)
+	lines := strings.SplitAfter(string(b), "\n")
+	corrupt := lines[0] + "{not an event}\n" + strings.Join(lines[1:], "")
+	check(t, ioutil.WriteFile(path+".journal", []byte(corrupt), 0600))
+	if _, e := NewFile(path); e == nil {
+		t.Error("a journal with a corrupt event was loaded")
+	}
+}
+
+// fill makes changes of every kind to a store
+func fill(t *testing.T, m Manager) {
+	t.Helper()
+	reserveAll(t, m, alice, bob, carol, dave)
+	check(t, m.Swap(carol, dave, "web", "qa"))
+	check(t, m.Handoff(alice, bob, "web", "qa"))
+	check(t, m.UpdateResource("web", "qa", func(r *models.Resource) error {
+		r.Capacity = 2
+		r.Tags = []string{"frontend"}
+		return nil
+	}))
+	check(t, m.UpdateReservation(dave, "web", "qa", func(res *models.Reservation) error {
+		res.Note = "testing the login flow"
+		res.Hold = time.Hour
+		return nil
+	}))
+	check(t, m.ReserveAll(alice, []*models.Resource{{Name: "api", Env: "qa"}, {Name: "db", Env: "qa"}}))
+	check(t, m.Reserve(bob, "api", "dev"))
+	check(t, m.Kick(bob, "api", "dev"))
+
+	now := time.Now().Truncate(time.Second)
+	check(t, m.AddBooking(&models.Booking{ID: "standup", User: carol, Name: "db", Env: "qa", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour), Every: []time.Weekday{time.Monday}}))
+	check(t, m.AddBooking(&models.Booking{ID: "demo", User: dave, Name: "web", Env: "qa", Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)}))
+	check(t, m.RemoveBooking("demo"))
+}
+
+// dump describes the state of a store, leaving out what stores are free to differ on
+func dump(m Manager) string {
+	ret := ""
+	for _, r := range m.GetResources() {
+		ret += fmt.Sprintf("%s capacity %d tags %v\n", r, r.Holders(), r.Tags)
+		q, e := m.GetQueueForResource(r.Name, r.Env)
+		if e != nil {
+			ret += e.Error() + "\n"
+			continue
+		}
+		for i, res := range q.Reservations {
+			ret += fmt.Sprintf("  %d %s held %t note %q hold %s pending %v\n", i+1, res.User.ID, i < len(q.Holders()), res.Note, res.Hold, res.Composite)
+		}
+	}
+	for _, b := range m.GetBookings() {
+		ret += fmt.Sprintf("booking %s of %s|%s by %s from %d to %d every %v\n", b.ID, b.Env, b.Name, b.User.ID, b.Start.Unix(), b.End.Unix(), b.Every)
+	}
+	for _, ev := range m.GetEvents(time.Time{}) {
+		// Not every store records the implicit creation of a resource
+		if ev.Type != models.ResourceCreated {
+			ret += fmt.Sprintf("event %s %s|%s held %t\n", ev.Type, ev.Env, ev.Name, ev.Held > 0)
+		}
+	}
+	return ret
+}
+
+func TestConformance(t *testing.T) {
+	for _, s := range stores {
+		s := s
+		for _, sc := range scenarios {
+			sc := sc
+			t.Run(s.name+"/"+sc.name, func(t *testing.T) {
+				sc.run(t, s.open(t))
+			})
+		}
+	}
+}
+
+var (
+	alice = &models.User{ID: "alice", Name: "alice"}
+	bob   = &models.User{ID: "bob", Name: "bob"}
+	carol = &models.User{ID: "carol", Name: "carol"}
+	dave  = &models.User{ID: "dave", Name: "dave"}
+)
+
+// openPostgres returns a store in a new schema of the Postgres database at RESERVEBOT_TEST_POSTGRES, e.g.
+// postgres://postgres@localhost/reservebot?sslmode=disable. The scenarios are skipped if it isn't set.
+func openPostgres(t *testing.T) Manager {
+	dsn := os.Getenv("RESERVEBOT_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("RESERVEBOT_TEST_POSTGRES is not set")
+	}
+
+	db, e := sql.Open("postgres", dsn)
+	if e != nil {
+		t.Fatal(e)
+	}
+	schema := fmt.Sprintf("reservebot_test_%d", time.Now().UnixNano())
+	_, e = db.Exec("CREATE SCHEMA " + schema)
+	if e != nil {
+		db.Close()
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+
+	u, e := url.Parse(dsn)
+	if e != nil {
+		t.Fatal(e)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	s, e := NewSQL(u.String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func tempDir(t *testing.T) string {
+	dir, e := ioutil.TempDir("", "reservebot")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func check(t *testing.T, e error) {
+	t.Helper()
+	if e != nil {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+}
+
+func expect(t *testing.T, got, want error) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got error %v, want %v", got, want)
+	}
+}
+
+// reserveAll queues the users for qa|web in order
+func reserveAll(t *testing.T, m Manager, users ...*models.User) {
+	t.Helper()
+	for _, u := range users {
+		check(t, m.Reserve(u, "web", "qa"))
+	}
+}
+
+// ids returns the IDs of the users with the reservations, in order
+func ids(reservations []*models.Reservation) string {
+	ret := []string{}
+	for _, res := range reservations {
+		ret = append(ret, res.User.ID)
+	}
+	return strings.Join(ret, ",")
+}
+
+func expectQueue(t *testing.T, m Manager, name, env, want string) {
+	t.Helper()
+	q, e := m.GetQueueForResource(name, env)
+	check(t, e)
+	if got := ids(q.Reservations); got != want {
+		t.Errorf("queue is %s, want %s", got, want)
+	}
+}
+
+func expectBookings(t *testing.T, m Manager, want string) {
+	t.Helper()
+	got := []string{}
+	for _, b := range m.GetBookings() {
+		got = append(got, b.ID)
+	}
+	if strings.Join(got, ",") != want {
+		t.Errorf("bookings are %s, want %s", strings.Join(got, ","), want)
+	}
+}
@@ -0,0 +1,455 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotAfter is the number of events after which a new snapshot is written
+var snapshotAfter = 1000
+
+// snapshot is the on-disk representation of the state after the events in the first Segments journal
+// segments. Reservations are stored in queue order.
+type snapshot struct {
+	Segments     int                    `json:"segments"`
+	Resources    []*models.Resource     `json:"resources"`
+	Reservations []*snapshotReservation `json:"reservations"`
+	Bookings     []*models.Booking      `json:"bookings"`
+}
+
+type snapshotReservation struct {
+	*models.Reservation
+	Name string `json:"name"`
+	Env  string `json:"env"`
+}
+
+// File is a Manager that keeps its state in memory and persists it to disk. Every event is appended to a
+// journal at path.journal, and the state is a projection of the journal. Every snapshotAfter events, the
+// journal is moved aside as the next numbered segment, path.journal.1 and so on, and a JSON snapshot of the
+// projection is written to path. On startup, the snapshot is loaded and only the events after it are
+// replayed. Only those events are kept in memory. Older ones are read from the segments when asked for.
+type File struct {
+	*Memory
+
+	path     string
+	journal  *os.File
+	unsynced int
+	// segments is the number of journal segments that have been moved aside
+	segments int
+	// failed is the error that stopped an event from being written to the journal. Once it is set, no more
+	// events are accepted, since the state in memory is ahead of the journal.
+	failed error
+
+	lock sync.Mutex
+}
+
+func NewFile(path string) (*File, error) {
+	f := &File{
+		Memory: NewMemory(),
+		path:   path,
+	}
+
+	err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	f.journal, err = os.OpenFile(f.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Close closes the journal. No more events are accepted afterwards.
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.failed = fmt.Errorf("journal %s is closed", f.journalPath())
+	return f.journal.Close()
+}
+
+func (f *File) journalPath() string {
+	return f.path + ".journal"
+}
+
+// segmentPath returns the path of the nth journal segment, counting from 1
+func (f *File) segmentPath(n int) string {
+	return fmt.Sprintf("%s.%d", f.journalPath(), n)
+}
+
+// load reads the snapshot and replays the journal events after it into memory. Those are the events in
+// segments the snapshot doesn't include, which are left behind by a crash between moving the journal aside
+// and writing the snapshot, and the events in the journal.
+func (f *File) load() error {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(b) > 0 {
+		s := &snapshot{}
+		err := json.Unmarshal(b, s)
+		if err != nil {
+			return fmt.Errorf("reading snapshot %s: %w", f.path, err)
+		}
+
+		for _, r := range s.Resources {
+			f.Memory.Resources[r.Key()] = r
+		}
+		for _, sr := range s.Reservations {
+			sr.Resource = f.Memory.resource(sr.Name, sr.Env, true)
+			q := f.Memory.queue(sr.Resource)
+			q.Reservations = append(q.Reservations, sr.Reservation)
+		}
+		if s.Bookings != nil {
+			f.Memory.Bookings = s.Bookings
+		}
+		f.segments = s.Segments
+	}
+
+	replay := func(ev *models.Event) {
+		// Errors are ignored on replay. They were returned to the caller when the event was first applied.
+		f.Memory.apply(ev)
+	}
+	for {
+		_, err := readJournal(f.segmentPath(f.segments+1), replay)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		f.segments++
+	}
+	// Only the events in the journal are kept in memory. The others can be read from the segments.
+	f.Memory.Events = []*models.Event{}
+
+	offset, err := readJournal(f.journalPath(), func(ev *models.Event) {
+		replay(ev)
+		f.unsynced++
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A crash while appending can leave a partially written event at the end. Drop it so that new events
+	// are appended after the last complete one.
+	return os.Truncate(f.journalPath(), offset)
+}
+
+// readJournal calls visit with every event in a journal, in order, and returns the offset of the end of the
+// last complete one
+func readJournal(path string, visit func(ev *models.Event)) (int64, error) {
+	j, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer j.Close()
+
+	offset := int64(0)
+	reader := bufio.NewReader(j)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		ev := &models.Event{}
+		err = json.Unmarshal(line, ev)
+		if err != nil {
+			// Only the last line can be cut short by a crash, and it has no newline. Anything else means the
+			// journal is corrupt, and going on would lose the events after it.
+			return offset, fmt.Errorf("reading journal %s at offset %d: %w", path, offset, err)
+		}
+		offset += int64(len(line))
+		visit(ev)
+	}
+}
+
+// do applies an event and, if it succeeds, appends it to the journal. If the event can't be written, the
+// store stops accepting events, so that the state in memory never gets further ahead of the journal.
+func (f *File) do(ev *models.Event) error {
+	return f.doWith(func() (*models.Event, error) {
+		return ev, nil
+	})
+}
+
+// doWith applies and journals the event returned by build, which is called under the lock of the state in
+// memory so that it can read it to describe the change
+func (f *File) doWith(build func() (*models.Event, error)) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failed != nil {
+		return f.failed
+	}
+
+	var ev *models.Event
+	err := f.Memory.applyWith(func() (*models.Event, error) {
+		var err error
+		ev, err = build()
+		if err != nil {
+			return nil, err
+		}
+		ev.Time = time.Now()
+		return ev, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = f.write(ev)
+	if err != nil {
+		f.failed = fmt.Errorf("writing journal %s: %w", f.journalPath(), err)
+		return f.failed
+	}
+
+	f.unsynced++
+	if f.unsynced >= snapshotAfter {
+		// The event has been applied and journalled, so only the snapshot is behind. It is tried again after
+		// the next event.
+		err = f.snapshot()
+		if err != nil {
+			log.Errorf("writing snapshot %s: %+v", f.path, err)
+		}
+	}
+
+	return nil
+}
+
+// write appends an event to the journal
+func (f *File) write(ev *models.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = f.journal.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return f.journal.Sync()
+}
+
+// rotate moves the journal aside as the next segment and starts a new one. The events it held are dropped from
+// memory, since they can be read from the segment.
+func (f *File) rotate() error {
+	err := os.Rename(f.journalPath(), f.segmentPath(f.segments+1))
+	if err != nil {
+		return err
+	}
+	f.segments++
+	f.unsynced = 0
+
+	f.Memory.lock.Lock()
+	f.Memory.Events = []*models.Event{}
+	f.Memory.lock.Unlock()
+
+	// The old journal is still open, now under the name of the segment
+	f.journal.Close()
+	f.journal, err = os.OpenFile(f.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		f.failed = fmt.Errorf("opening journal %s: %w", f.journalPath(), err)
+		return f.failed
+	}
+	return nil
+}
+
+// snapshot moves the journal aside if it has any events and writes the current state to a new snapshot
+func (f *File) snapshot() error {
+	if f.unsynced > 0 {
+		err := f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	s := &snapshot{
+		Segments:     f.segments,
+		Resources:    f.Memory.GetResources(),
+		Reservations: []*snapshotReservation{},
+		Bookings:     f.Memory.GetBookings(),
+	}
+
+	f.Memory.lock.Lock()
+	for _, r := range s.Resources {
+		q, ok := f.Memory.Queues[r.Key()]
+		if !ok {
+			continue
+		}
+		for _, res := range q.Reservations {
+			s.Reservations = append(s.Reservations, &snapshotReservation{
+				Reservation: res,
+				Name:        res.Resource.Name,
+				Env:         res.Resource.Env,
+			})
+		}
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	f.Memory.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can never leave a partial snapshot behind
+	tmp := f.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// GetEvents returns the events since a time. Those from before the journal was last moved aside are read from
+// its segments, newest first, until one starts before since.
+func (f *File) GetEvents(since time.Time) []*models.Event {
+	f.lock.Lock()
+	segments := f.segments
+	recent := f.Memory.GetEvents(time.Time{})
+	f.lock.Unlock()
+
+	ret := []*models.Event{}
+	if len(recent) == 0 || !recent[0].Time.Before(since) {
+		for n := segments; n > 0; n-- {
+			events := []*models.Event{}
+			_, err := readJournal(f.segmentPath(n), func(ev *models.Event) {
+				events = append(events, ev)
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				break
+			}
+			ret = append(events, ret...)
+			if len(events) > 0 && events[0].Time.Before(since) {
+				break
+			}
+		}
+	}
+	ret = append(ret, recent...)
+
+	i := 0
+	for i < len(ret) && ret[i].Time.Before(since) {
+		i++
+	}
+	return ret[i:]
+}
+
+func (f *File) Create(name, env string) error {
+	return f.do(&models.Event{Type: models.ResourceCreated, Name: name, Env: env})
+}
+
+func (f *File) Reserve(u *models.User, name, env string) error {
+	return f.do(&models.Event{Type: models.Reserved, User: u, Name: name, Env: env})
+}
+
+func (f *File) ReserveAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return f.do(&models.Event{Type: models.ReservedAll, User: u, Resources: resources})
+}
+
+func (f *File) GrantAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return f.do(&models.Event{Type: models.GrantedAll, User: u, Resources: resources})
+}
+
+func (f *File) Remove(u *models.User, name, env string) error {
+	return f.do(&models.Event{Type: models.Removed, User: u, Name: name, Env: env})
+}
+
+func (f *File) Kick(u *models.User, name, env string) error {
+	return f.do(&models.Event{Type: models.Kicked, User: u, Name: name, Env: env})
+}
+
+// GetResource will journal the creation of a resource if create is set and the resource doesn't exist
+func (f *File) GetResource(name, env string, create bool) *models.Resource {
+	r := f.Memory.GetResource(name, env, false)
+	if r != nil || !create {
+		return r
+	}
+
+	err := f.Create(name, env)
+	if err != nil {
+		return nil
+	}
+	return f.Memory.GetResource(name, env, false)
+}
+
+func (f *File) RemoveResource(name, env string) error {
+	return f.do(&models.Event{Type: models.ResourceRemoved, Name: name, Env: env})
+}
+
+func (f *File) RemoveEnv(name, env string) error {
+	return f.do(&models.Event{Type: models.EnvRemoved, Name: name, Env: env})
+}
+
+func (f *File) ClearQueueForResource(name, env string) error {
+	return f.do(&models.Event{Type: models.Cleared, Name: name, Env: env})
+}
+
+func (f *File) PruneInactiveResources(hours int) error {
+	return pruneInactiveResources(f, hours, func(name, env string) error {
+		return f.do(&models.Event{Type: models.Pruned, Name: name, Env: env})
+	})
+}
+
+func (f *File) UpdateResource(name, env string, update func(r *models.Resource) error) error {
+	return f.doWith(func() (*models.Event, error) {
+		return f.Memory.resourceUpdated(name, env, update)
+	})
+}
+
+func (f *File) UpdateReservation(u *models.User, name, env string, update func(res *models.Reservation) error) error {
+	return f.doWith(func() (*models.Event, error) {
+		return f.Memory.reservationUpdated(u, name, env, update)
+	})
+}
+
+func (f *File) Move(u *models.User, name, env string, pos int) error {
+	return f.do(&models.Event{Type: models.Moved, User: u, Name: name, Env: env, Position: pos})
+}
+
+func (f *File) AddBooking(b *models.Booking) error {
+	c := *b
+	return f.do(&models.Event{Type: models.BookingAdded, User: b.User, Name: b.Name, Env: b.Env, Booking: &c})
+}
+
+func (f *File) UpdateBooking(b *models.Booking) error {
+	c := *b
+	return f.do(&models.Event{Type: models.BookingUpdated, User: b.User, Name: b.Name, Env: b.Env, Booking: &c})
+}
+
+func (f *File) RemoveBooking(id string) error {
+	return f.do(&models.Event{Type: models.BookingRemoved, Booking: &models.Booking{ID: id}})
+}
+
+func (f *File) Swap(a, b *models.User, name, env string) error {
+	return f.do(&models.Event{Type: models.Swapped, User: a, Target: b, Name: name, Env: env})
+}
+
+func (f *File) Handoff(u, to *models.User, name, env string) error {
+	return f.do(&models.Event{Type: models.HandedOff, User: u, Target: to, Name: name, Env: env})
+}
+
+func (f *File) Nuke() error {
+	return f.do(&models.Event{Type: models.Nuked})
+}
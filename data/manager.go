@@ -1,7 +1,11 @@
 package data
 
 import (
+	"sort"
+	"time"
+
 	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
 )
 
 type Manager interface {
@@ -17,8 +21,129 @@ type Manager interface {
 	GetResources() []*models.Resource
 	GetResourcesForEnv(env string) []*models.Resource
 	Remove(u *models.User, name string, env string) error
+	Kick(u *models.User, name string, env string) error
 	RemoveEnv(name string, env string) error
 	RemoveResource(name string, env string) error
 	Reserve(u *models.User, name string, env string) error
+	// ReserveAll queues the user for every resource at once as a single all-or-nothing request, or for none
+	// of them if they are already in line for any. The reservations are pending until GrantAll.
+	ReserveAll(u *models.User, resources []*models.Resource) error
+	// GrantAll hands every resource of the user's pending all-or-nothing request over to them at once. Unless
+	// every pending reservation has got to the front of its queue, it fails with NotHolder and changes nothing.
+	GrantAll(u *models.User, resources []*models.Resource) error
 	ClearQueueForResource(name, env string) error
+	PruneInactiveResources(hours int) error
+	Nuke() error
+	GetEvents(since time.Time) []*models.Event
+	// UpdateResource changes a resource by calling update with a copy of it while the store holds the lock
+	// or transaction of the resource, so that concurrent updates can't undo each other. update may be called
+	// more than once. If it returns an error, nothing is changed and the error is returned.
+	UpdateResource(name string, env string, update func(r *models.Resource) error) error
+	// UpdateReservation changes the user's reservation for a resource the same way. Its time is not changed.
+	UpdateReservation(u *models.User, name string, env string, update func(res *models.Reservation) error) error
+	// Move moves a user to pos in a resource's queue, where 1 is the front
+	Move(u *models.User, name string, env string, pos int) error
+	// Swap swaps the places of two users in a resource's queue
+	Swap(a *models.User, b *models.User, name string, env string) error
+	// Handoff hands a resource the user holds over to another user, who takes their place among the holders
+	Handoff(u *models.User, to *models.User, name string, env string) error
+	AddBooking(b *models.Booking) error
+	UpdateBooking(b *models.Booking) error
+	RemoveBooking(id string) error
+	// GetBookings returns all bookings ordered by start
+	GetBookings() []*models.Booking
+}
+
+// pruneInactiveResources removes all resources without reservations that have not seen any activity
+// within the given number of hours. prune is called to remove each resource so that it can be recorded
+// as pruned.
+func pruneInactiveResources(m Manager, hours int, prune func(name, env string) error) error {
+	resources := m.GetResources()
+	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	for _, r := range resources {
+		q, err := m.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if q.HasReservations() {
+			continue
+		}
+		if r.LastActivity.Before(oldestTime) {
+			err := prune(r.Name, r.Env)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// composite returns the distinct resources of an all-or-nothing request ordered by key, with only their names
+// and envs set, along with their keys
+func composite(resources []*models.Resource) ([]*models.Resource, []string) {
+	byKey := map[string]*models.Resource{}
+	keys := []string{}
+	for _, r := range resources {
+		if _, ok := byKey[r.Key()]; ok {
+			continue
+		}
+		byKey[r.Key()] = &models.Resource{Name: r.Name, Env: r.Env}
+		keys = append(keys, r.Key())
+	}
+	sort.Strings(keys)
+
+	ret := []*models.Resource{}
+	for _, k := range keys {
+		ret = append(ret, byKey[k])
+	}
+	return ret, keys
+}
+
+// sortBookings orders bookings by their start, then by ID
+func sortBookings(bookings []*models.Booking) {
+	sort.Slice(bookings, func(i, j int) bool {
+		if !bookings[i].Start.Equal(bookings[j].Start) {
+			return bookings[i].Start.Before(bookings[j].Start)
+		}
+		return bookings[i].ID < bookings[j].ID
+	})
+}
+
+// Replay applies events in order to a new Memory, stopping at the first event after until. This is used to
+// rebuild state from a log or to find out what the state was at a point in time.
+func Replay(events []*models.Event, until time.Time) *Memory {
+	m := NewMemory()
+	for _, ev := range events {
+		if ev.Time.After(until) {
+			break
+		}
+		// Errors are ignored on replay. They were returned to the caller when the event was first applied.
+		m.apply(ev)
+	}
+	return m
+}
+
+// Walk applies events in order to a new Memory, calling visit with the state after each one. This is used
+// to find out how the state changed over time.
+func Walk(events []*models.Event, visit func(ev *models.Event, m *Memory)) {
+	m := NewMemory()
+	for _, ev := range events {
+		// Errors are ignored, as on replay
+		m.apply(ev)
+		visit(ev, m)
+	}
+}
+
+// reverseEvents reverses the order of events in place, for stores that read the newest first
+func reverseEvents(events []*models.Event) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
+
+// StateAt returns a copy of the state as it was at t
+func StateAt(m Manager, t time.Time) *Memory {
+	return Replay(m.GetEvents(time.Time{}), t)
 }
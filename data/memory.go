@@ -1,160 +1,483 @@
 package data
 
 import (
+	"fmt"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/ameliagapin/reservebot/err"
 	"github.com/ameliagapin/reservebot/models"
-	log "github.com/sirupsen/logrus"
 )
 
 type Memory struct {
-	Reservations []*models.Reservation
-	Resources    map[string]*models.Resource
+	// Queues holds the reservations of each resource in queue order, keyed by resource key
+	Queues    map[string]*models.Queue
+	Resources map[string]*models.Resource
+	Bookings  []*models.Booking
+	Events    []*models.Event
 
 	lock sync.Mutex
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		Reservations: []*models.Reservation{},
-		Resources:    map[string]*models.Resource{},
+		Queues:    map[string]*models.Queue{},
+		Resources: map[string]*models.Resource{},
+		Bookings:  []*models.Booking{},
+		Events:    []*models.Event{},
 	}
 }
 
+// apply performs the change described by an event and, if it succeeds, appends the event to the log. Both
+// happen under one hold of the lock, so the log always matches the state. The helpers it calls assume the
+// lock is held.
+// Removals from a queue recorded as Removed are turned into Released when the user was the holder.
+func (m *Memory) apply(ev *models.Event) error {
+	return m.applyWith(func() (*models.Event, error) {
+		return ev, nil
+	})
+}
+
+// applyWith applies the event returned by build, which is called under the same hold of the lock so that it
+// can read the current state to describe the change. Nothing is applied if build returns an error.
+func (m *Memory) applyWith(build func() (*models.Event, error)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ev, e := build()
+	if e != nil {
+		return e
+	}
+
+	switch ev.Type {
+	case models.ResourceCreated:
+		e = m.create(ev.Name, ev.Env, ev.Time)
+	case models.Reserved:
+		e = m.reserve(ev.User, ev.Name, ev.Env, ev.Time)
+	case models.ReservedAll:
+		e = m.reserveAll(ev.User, ev.Resources, ev.Time)
+	case models.GrantedAll:
+		e = m.grantAll(ev.User, ev.Resources, ev.Time)
+	case models.Released, models.Removed, models.Kicked:
+		var held bool
+		ev.Held = m.heldFor(ev.User, ev.Name, ev.Env, ev.Time)
+		held, e = m.remove(ev.User, ev.Name, ev.Env, ev.Time)
+		if ev.Type == models.Removed && held {
+			ev.Type = models.Released
+		}
+	case models.ResourceRemoved, models.Pruned:
+		e = m.removeResource(ev.Name, ev.Env)
+	case models.EnvRemoved:
+		e = m.removeEnv(ev.Env)
+	case models.Cleared:
+		e = m.clearQueueForResource(ev.Name, ev.Env, ev.Time)
+	case models.Nuked:
+		e = m.nuke()
+	case models.ResourceUpdated:
+		e = m.updateResource(ev.Resource, ev.Time)
+	case models.ReservationUpdated:
+		e = m.updateReservation(ev.User, ev.Name, ev.Env, ev.Reservation)
+	case models.Moved:
+		e = m.move(ev.User, ev.Name, ev.Env, ev.Position, ev.Time)
+	case models.Swapped:
+		e = m.swap(ev.User, ev.Target, ev.Name, ev.Env, ev.Time)
+	case models.HandedOff:
+		ev.Held = m.heldFor(ev.User, ev.Name, ev.Env, ev.Time)
+		e = m.handoff(ev.User, ev.Target, ev.Name, ev.Env, ev.Time)
+	case models.BookingAdded:
+		e = m.addBooking(ev.Booking)
+	case models.BookingUpdated:
+		e = m.updateBooking(ev.Booking)
+	case models.BookingRemoved:
+		e = m.removeBooking(ev.Booking.ID)
+	default:
+		e = fmt.Errorf("unknown event type %q", ev.Type)
+	}
+	if e != nil {
+		return e
+	}
+	m.Events = append(m.Events, ev)
+
+	return nil
+}
+
 func (m *Memory) Create(name, env string) error {
+	return m.apply(&models.Event{Type: models.ResourceCreated, Name: name, Env: env, Time: time.Now()})
+}
+
+func (m *Memory) create(name, env string, t time.Time) error {
 	// GetResource creates the resource if it doesn't exist
-	r := m.GetResource(name, env, true)
-	r.LastActivity = time.Now()
+	r := m.resource(name, env, true)
+	r.LastActivity = t
 
 	return nil
 }
 
 func (m *Memory) Reserve(u *models.User, name, env string) error {
-	r := m.GetResource(name, env, true)
+	return m.apply(&models.Event{Type: models.Reserved, User: u, Name: name, Env: env, Time: time.Now()})
+}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *Memory) reserve(u *models.User, name, env string, t time.Time) error {
+	r := m.resource(name, env, true)
 
 	// check for existing reservation
-	for _, res := range m.Reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return err.AlreadyInQueue
-			}
-		}
+	q := m.queue(r)
+	if q.Find(u) != -1 {
+		return err.AlreadyInQueue
 	}
 
 	res := &models.Reservation{
 		User:     u,
 		Resource: r,
-		Time:     time.Now(),
+		Time:     t,
 	}
 
-	m.Reservations = append(m.Reservations, res)
-	r.LastActivity = time.Now()
+	q.Reservations = append(q.Reservations, res)
+	r.LastActivity = t
 
 	return nil
 }
 
-func (m *Memory) GetReservation(u *models.User, name, env string) *models.Reservation {
-	r := m.GetResource(name, env, false)
+// ReserveAll queues the user for every resource at once as a single all-or-nothing request
+func (m *Memory) ReserveAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return m.apply(&models.Event{Type: models.ReservedAll, User: u, Resources: resources, Time: time.Now()})
+}
+
+func (m *Memory) reserveAll(u *models.User, resources []*models.Resource, t time.Time) error {
+	resources, keys := composite(resources)
+	for _, r := range resources {
+		existing := m.resource(r.Name, r.Env, false)
+		if existing != nil && m.queue(existing).Find(u) != -1 {
+			return err.AlreadyInQueue
+		}
+	}
+
+	for _, r := range resources {
+		existing := m.resource(r.Name, r.Env, true)
+		q := m.queue(existing)
+		q.Reservations = append(q.Reservations, &models.Reservation{
+			User:      u,
+			Resource:  existing,
+			Time:      t,
+			Composite: keys,
+		})
+		existing.LastActivity = t
+	}
+
+	return nil
+}
+
+// GrantAll hands every resource of the user's pending all-or-nothing request over to them at once
+func (m *Memory) GrantAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return m.apply(&models.Event{Type: models.GrantedAll, User: u, Resources: resources, Time: time.Now()})
+}
+
+func (m *Memory) grantAll(u *models.User, resources []*models.Resource, t time.Time) error {
+	pending := []*models.Reservation{}
+	for _, r := range resources {
+		existing := m.resource(r.Name, r.Env, false)
+		if existing == nil {
+			return err.ResourceDoesNotExist
+		}
+		q := m.queue(existing)
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		if idx >= len(q.Holders()) || !q.Reservations[idx].Pending() {
+			return err.NotHolder
+		}
+		pending = append(pending, q.Reservations[idx])
+	}
+
+	// The user starts holding all of them now
+	for _, res := range pending {
+		res.Composite = nil
+		res.Time = t
+		res.Resource.LastActivity = t
+	}
+
+	return nil
+}
+
+// UpdateResource changes a resource by calling update with a copy of it under the lock
+func (m *Memory) UpdateResource(name, env string, update func(r *models.Resource) error) error {
+	return m.applyWith(func() (*models.Event, error) {
+		return m.resourceUpdated(name, env, update)
+	})
+}
+
+// resourceUpdated returns the event that changes a resource with update. Does not implement lock.
+func (m *Memory) resourceUpdated(name, env string, update func(r *models.Resource) error) (*models.Event, error) {
+	r := m.resource(name, env, false)
 	if r == nil {
-		return nil
+		return nil, err.ResourceDoesNotExist
+	}
+
+	c := *r
+	e := update(&c)
+	if e != nil {
+		return nil, e
 	}
+	c.Name, c.Env = r.Name, r.Env
 
+	return &models.Event{Type: models.ResourceUpdated, Name: name, Env: env, Resource: &c, Time: time.Now()}, nil
+}
+
+func (m *Memory) updateResource(updated *models.Resource, t time.Time) error {
+	r := m.resource(updated.Name, updated.Env, false)
+	if r == nil {
+		return err.ResourceDoesNotExist
+	}
+
+	holders := r.Holders()
+	*r = *updated
+	r.LastActivity = t
+
+	// Raising the capacity moves the next users in line into the holders
+	if r.Holders() > holders {
+		m.queue(r).Promote(holders, t)
+	}
+
+	return nil
+}
+
+// UpdateReservation changes the user's reservation for a resource by calling update with a copy of it under
+// the lock. The time of the reservation is not changed.
+func (m *Memory) UpdateReservation(u *models.User, name, env string, update func(res *models.Reservation) error) error {
+	return m.applyWith(func() (*models.Event, error) {
+		return m.reservationUpdated(u, name, env, update)
+	})
+}
+
+// reservationUpdated returns the event that changes the user's reservation for a resource with update. Does
+// not implement lock.
+func (m *Memory) reservationUpdated(u *models.User, name, env string, update func(res *models.Reservation) error) (*models.Event, error) {
+	r := m.resource(name, env, false)
+	if r == nil {
+		return nil, err.ResourceDoesNotExist
+	}
+
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return nil, err.NotInQueue
+	}
+
+	rc := *r
+	c := copyReservation(q.Reservations[idx], &rc)
+	e := update(c)
+	if e != nil {
+		return nil, e
+	}
+	c.User = q.Reservations[idx].User
+
+	return &models.Event{Type: models.ReservationUpdated, User: c.User, Name: name, Env: env, Reservation: c, Time: time.Now()}, nil
+}
+
+func (m *Memory) updateReservation(u *models.User, name, env string, updated *models.Reservation) error {
+	r := m.resource(name, env, false)
+	if r == nil {
+		return err.ResourceDoesNotExist
+	}
+
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return err.NotInQueue
+	}
+
+	res := q.Reservations[idx]
+	t := res.Time
+	*res = *updated
+	res.Resource = r
+	res.Time = t
+
+	return nil
+}
+
+func (m *Memory) GetReservation(u *models.User, name, env string) *models.Reservation {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	for _, res := range m.Reservations {
-		if res.User.ID == u.ID {
-			if res.Resource.Key() == r.Key() {
-				return res
-			}
-		}
+	r := m.resource(name, env, false)
+	if r == nil {
+		return nil
 	}
-	return nil
+
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return nil
+	}
+	c := *r
+	return copyReservation(q.Reservations[idx], &c)
+}
+
+// copyReservation returns a copy of a reservation that belongs to the given copy of its resource, so that
+// changing it doesn't change the stored one
+func copyReservation(res *models.Reservation, r *models.Resource) *models.Reservation {
+	c := *res
+	c.Resource = r
+	return &c
 }
 
 // Remove removes a user from a resource's queue.
 // If the removal advances the queue, the new resource holder's reservation will have the time updated
 func (m *Memory) Remove(u *models.User, name, env string) error {
-	// minor optimization: if the resource doesn't exist, there's no need to loop through all reservations
-	r := m.GetResource(name, env, false)
+	return m.apply(&models.Event{Type: models.Removed, User: u, Name: name, Env: env, Time: time.Now()})
+}
+
+// Kick removes a user from a resource's queue on behalf of someone else
+func (m *Memory) Kick(u *models.User, name, env string) error {
+	return m.apply(&models.Event{Type: models.Kicked, User: u, Name: name, Env: env, Time: time.Now()})
+}
+
+// remove removes a user from a resource's queue and returns whether they were holding it
+func (m *Memory) remove(u *models.User, name, env string, t time.Time) (bool, error) {
+	r := m.resource(name, env, false)
 	if r == nil {
-		return err.ResourceDoesNotExist
+		return false, err.ResourceDoesNotExist
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return false, err.NotInQueue
+	}
 
-	idx := -1
-	pos := 0
-	for i, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			pos++
-			if res.User.ID == u.ID {
-				idx = i
-				break
-			}
-		}
+	// if the user was a holder, then removal moves the next user in line into the holders. This updates
+	// the time on their res
+	held := q.Remove(idx, t)
+
+	r.LastActivity = t
+
+	return held, nil
+}
+
+// heldFor returns how long the user has held a resource at t, or zero if they don't hold it
+func (m *Memory) heldFor(u *models.User, name, env string, t time.Time) time.Duration {
+	r := m.resource(name, env, false)
+	if r == nil {
+		return 0
+	}
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return 0
+	}
+	return q.HeldFor(idx, t)
+}
+
+// Move moves a user to pos in a resource's queue, where 1 is the front. Users who become holders because of
+// the move will have the time on their reservation updated.
+func (m *Memory) Move(u *models.User, name, env string, pos int) error {
+	return m.apply(&models.Event{Type: models.Moved, User: u, Name: name, Env: env, Position: pos, Time: time.Now()})
+}
+
+func (m *Memory) move(u *models.User, name, env string, pos int, t time.Time) error {
+	r := m.resource(name, env, false)
+	if r == nil {
+		return err.ResourceDoesNotExist
 	}
+
+	q := m.queue(r)
+	idx := q.Find(u)
 	if idx == -1 {
 		return err.NotInQueue
 	}
+	q.Move(idx, pos-1, t)
+	r.LastActivity = t
 
-	m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
+	return nil
+}
 
-	// if the user was in pos=1, then removal would move new user into pos=1. This should update the time on their res
-	if pos == 1 {
-		for _, res := range m.Reservations {
-			if res.Resource.Key() == r.Key() {
-				res.Time = time.Now()
-				break
-			}
-		}
+// Swap swaps the places of two users in a resource's queue. Users who become holders because of the swap
+// will have the time on their reservation updated.
+func (m *Memory) Swap(a, b *models.User, name, env string) error {
+	return m.apply(&models.Event{Type: models.Swapped, User: a, Target: b, Name: name, Env: env, Time: time.Now()})
+}
+
+func (m *Memory) swap(a, b *models.User, name, env string, t time.Time) error {
+	r := m.resource(name, env, false)
+	if r == nil {
+		return err.ResourceDoesNotExist
 	}
 
-	r.LastActivity = time.Now()
+	q := m.queue(r)
+	i, j := q.Find(a), q.Find(b)
+	if i == -1 || j == -1 {
+		return err.NotInQueue
+	}
+	q.Swap(i, j, t)
+	r.LastActivity = t
 
 	return nil
 }
 
-func (m *Memory) GetPosition(u *models.User, name, env string) (int, error) {
-	r := m.GetResource(name, env, false)
+// Handoff hands a resource the user holds over to another user, who takes their place among the holders
+// and starts holding it now. The other user's place in the queue, if they had one, is given up.
+func (m *Memory) Handoff(u, to *models.User, name, env string) error {
+	return m.apply(&models.Event{Type: models.HandedOff, User: u, Target: to, Name: name, Env: env, Time: time.Now()})
+}
+
+func (m *Memory) handoff(u, to *models.User, name, env string, t time.Time) error {
+	r := m.resource(name, env, false)
 	if r == nil {
-		return 0, err.ResourceDoesNotExist
+		return err.ResourceDoesNotExist
+	}
+
+	q := m.queue(r)
+	idx := q.Find(u)
+	if idx == -1 {
+		return err.NotInQueue
+	}
+	if idx >= len(q.Holders()) {
+		return err.NotHolder
 	}
+	q.Handoff(idx, to, t)
+	r.LastActivity = t
 
+	return nil
+}
+
+func (m *Memory) GetPosition(u *models.User, name, env string) (int, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	pos := 0
-	inQueue := false
-
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			// increment pos first because want to return zero-based index
-			pos++
-			if res.User.ID == u.ID {
-				inQueue = true
-				break
-			}
-		}
+	r := m.resource(name, env, false)
+	if r == nil {
+		return 0, err.ResourceDoesNotExist
 	}
-	if !inQueue {
+
+	idx := m.queue(r).Find(u)
+	if idx == -1 {
 		return 0, err.NotInQueue
 	}
 
-	return pos, nil
+	// positions are one-based
+	return idx + 1, nil
 }
 
+// GetResource returns a copy of a resource, so that changing it doesn't change the stored one
 func (m *Memory) GetResource(name, env string, create bool) *models.Resource {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	r := m.resource(name, env, create)
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
+// resource returns the live resource, creating it if create is set and the resource doesn't exist. Does not
+// implement lock.
+func (m *Memory) resource(name, env string, create bool) *models.Resource {
 	key := models.ResourceKey(name, env)
 	r, ok := m.Resources[key]
 	if !ok {
@@ -170,33 +493,30 @@ func (m *Memory) GetResource(name, env string, create bool) *models.Resource {
 }
 
 func (m *Memory) RemoveResource(name, env string) error {
-	r := m.GetResource(name, env, false)
+	return m.apply(&models.Event{Type: models.ResourceRemoved, Name: name, Env: env, Time: time.Now()})
+}
+
+func (m *Memory) removeResource(name, env string) error {
+	r := m.resource(name, env, false)
 	if r == nil {
 		return err.ResourceDoesNotExist
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for idx, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
-		}
-	}
-
+	delete(m.Queues, r.Key())
 	delete(m.Resources, r.Key())
 
 	return nil
 }
 
 func (m *Memory) RemoveEnv(name, env string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	return m.apply(&models.Event{Type: models.EnvRemoved, Name: name, Env: env, Time: time.Now()})
+}
 
+func (m *Memory) removeEnv(env string) error {
 	exists := false
-	for idx, res := range m.Reservations {
-		if res.Resource.Env == env {
-			m.Reservations = append(m.Reservations[:idx], m.Reservations[idx+1:]...)
+	for k, q := range m.Queues {
+		if q.Resource.Env == env {
+			delete(m.Queues, k)
 			exists = true
 		}
 	}
@@ -215,6 +535,7 @@ func (m *Memory) RemoveEnv(name, env string) error {
 	return nil
 }
 
+// GetResources returns copies of all resources, ordered by key
 func (m *Memory) GetResources() []*models.Resource {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -227,7 +548,8 @@ func (m *Memory) GetResources() []*models.Resource {
 
 	ret := []*models.Resource{}
 	for _, k := range keys {
-		ret = append(ret, m.Resources[k])
+		c := *m.Resources[k]
+		ret = append(ret, &c)
 	}
 
 	return ret
@@ -247,45 +569,56 @@ func (m *Memory) GetQueues() []*models.Queue {
 }
 
 func (m *Memory) GetQueueForResource(name, env string) (*models.Queue, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	// minor optimization
-	r := m.GetResource(name, env, false)
+	r := m.resource(name, env, false)
 	if r == nil {
 		return nil, err.ResourceDoesNotExist
 	}
 
+	// The returned queue is a copy, so neither its order nor its reservations can be changed by the caller
+	c := *r
 	ret := &models.Queue{
-		Resource: r,
+		Resource:     &c,
+		Reservations: []*models.Reservation{},
 	}
+	for _, res := range m.queue(r).Reservations {
+		ret.Reservations = append(ret.Reservations, copyReservation(res, &c))
+	}
+	return ret, nil
+}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			ret.Reservations = append(ret.Reservations, res)
+// queue returns the live queue for a resource, creating it if it doesn't exist. Does not implement lock.
+func (m *Memory) queue(r *models.Resource) *models.Queue {
+	q, ok := m.Queues[r.Key()]
+	if !ok {
+		q = &models.Queue{
+			Resource:     r,
+			Reservations: []*models.Reservation{},
 		}
+		m.Queues[r.Key()] = q
 	}
-
-	return ret, nil
+	return q
 }
 
 func (m *Memory) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	// minor optimization
-	r := m.GetResource(name, env, false)
+	r := m.resource(name, env, false)
 	if r == nil {
 		return nil, err.ResourceDoesNotExist
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, res := range m.Reservations {
-		if res.Resource.Key() == r.Key() {
-			return res, nil
-		}
+	q := m.queue(r)
+	if !q.HasReservations() {
+		return nil, nil
 	}
-
-	return nil, nil
+	c := *r
+	return copyReservation(q.Reservations[0], &c), nil
 }
 
 // Does not implement lock
@@ -301,6 +634,7 @@ func (m *Memory) GetQueuesForEnv(env string) map[string]*models.Queue {
 	return ret
 }
 
+// GetResourcesForEnv returns copies of the resources in an env, ordered by key
 func (m *Memory) GetResourcesForEnv(env string) []*models.Resource {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -315,7 +649,8 @@ func (m *Memory) GetResourcesForEnv(env string) []*models.Resource {
 
 	ret := []*models.Resource{}
 	for _, k := range keys {
-		ret = append(ret, m.Resources[k])
+		c := *m.Resources[k]
+		ret = append(ret, &c)
 	}
 	return ret
 }
@@ -326,8 +661,10 @@ func (m *Memory) GetAllUsersInQueues() []*models.User {
 
 	all := map[string]*models.User{}
 
-	for _, r := range m.Reservations {
-		all[r.User.ID] = r.User
+	for _, q := range m.Queues {
+		for _, res := range q.Reservations {
+			all[res.User.ID] = res.User
+		}
 	}
 
 	ret := []*models.User{}
@@ -339,45 +676,108 @@ func (m *Memory) GetAllUsersInQueues() []*models.User {
 }
 
 func (m *Memory) ClearQueueForResource(name, env string) error {
+	return m.apply(&models.Event{Type: models.Cleared, Name: name, Env: env, Time: time.Now()})
+}
+
+func (m *Memory) clearQueueForResource(name, env string, t time.Time) error {
 	// minor optimization
-	r := m.GetResource(name, env, false)
+	r := m.resource(name, env, false)
 	if r == nil {
 		return err.ResourceDoesNotExist
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	filtered := []*models.Reservation{}
-	for _, res := range m.Reservations {
-		if res.Resource.Key() != r.Key() {
-			filtered = append(filtered, res)
-		}
-	}
-	m.Reservations = filtered
-	r.LastActivity = time.Now()
+	delete(m.Queues, r.Key())
+	r.LastActivity = t
 
 	return nil
 }
 
 func (m *Memory) PruneInactiveResources(hours int) error {
-	resources := m.GetResources()
-	oldestTime := time.Now().Add(-time.Duration(hours) * time.Hour)
+	return pruneInactiveResources(m, hours, func(name, env string) error {
+		return m.apply(&models.Event{Type: models.Pruned, Name: name, Env: env, Time: time.Now()})
+	})
+}
 
-	for _, r := range resources {
-		q, err := m.GetQueueForResource(r.Name, r.Env)
-		if err != nil {
-			log.Errorf("%+v", err)
+// Nuke removes all resources and reservations. The event log is kept.
+func (m *Memory) Nuke() error {
+	return m.apply(&models.Event{Type: models.Nuked, Time: time.Now()})
+}
+
+func (m *Memory) nuke() error {
+	m.Queues = map[string]*models.Queue{}
+	m.Resources = map[string]*models.Resource{}
+
+	return nil
+}
+
+func (m *Memory) AddBooking(b *models.Booking) error {
+	c := *b
+	return m.apply(&models.Event{Type: models.BookingAdded, User: b.User, Name: b.Name, Env: b.Env, Booking: &c, Time: time.Now()})
+}
+
+func (m *Memory) addBooking(b *models.Booking) error {
+	c := *b
+	m.Bookings = append(m.Bookings, &c)
+	sortBookings(m.Bookings)
+
+	return nil
+}
+
+// UpdateBooking replaces the attributes of the booking with the same ID as b
+func (m *Memory) UpdateBooking(b *models.Booking) error {
+	c := *b
+	return m.apply(&models.Event{Type: models.BookingUpdated, User: b.User, Name: b.Name, Env: b.Env, Booking: &c, Time: time.Now()})
+}
+
+func (m *Memory) updateBooking(b *models.Booking) error {
+	for _, existing := range m.Bookings {
+		if existing.ID == b.ID {
+			*existing = *b
+			sortBookings(m.Bookings)
+			return nil
 		}
-		if q.HasReservations() {
-			continue
+	}
+
+	return err.BookingDoesNotExist
+}
+
+func (m *Memory) RemoveBooking(id string) error {
+	return m.apply(&models.Event{Type: models.BookingRemoved, Booking: &models.Booking{ID: id}, Time: time.Now()})
+}
+
+func (m *Memory) removeBooking(id string) error {
+	for i, b := range m.Bookings {
+		if b.ID == id {
+			m.Bookings = append(m.Bookings[:i:i], m.Bookings[i+1:]...)
+			return nil
 		}
-		if r.LastActivity.Before(oldestTime) {
-			err := m.RemoveResource(r.Name, r.Env)
-			if err != nil {
-				log.Errorf("%+v", err)
-			}
+	}
+
+	return err.BookingDoesNotExist
+}
+
+// GetBookings returns copies of all bookings, ordered by start
+func (m *Memory) GetBookings() []*models.Booking {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := []*models.Booking{}
+	for _, b := range m.Bookings {
+		c := *b
+		ret = append(ret, &c)
+	}
+	return ret
+}
+
+func (m *Memory) GetEvents(since time.Time) []*models.Event {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := []*models.Event{}
+	for _, ev := range m.Events {
+		if !ev.Time.Before(since) {
+			ret = append(ret, ev)
 		}
 	}
-	return nil
+	return ret
 }
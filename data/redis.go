@@ -0,0 +1,1024 @@
+package data
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/go-redis/redis/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// redisMaxRetries is the number of times a transaction is retried when another client modified the
+// watched keys before it could commit
+const redisMaxRetries = 10
+
+const (
+	redisKeyResources = "reservebot:resources"
+	redisKeyResource  = "reservebot:resource:"
+	redisKeyHistory   = "reservebot:history"
+	redisKeyQueue     = "reservebot:queue:"
+	redisKeyBookings  = "reservebot:bookings"
+)
+
+// Redis is a Manager backed by Redis so that several reservebot instances can share one consistent state.
+// Each resource is stored under a key of its own, with the set of all resource keys kept alongside, each
+// queue is a list, bookings are stored in a single hash and history is a list of events.
+// Mutations are performed in WATCH/MULTI transactions and retried if another instance got there first. A
+// change to one resource only watches the keys of that resource, so changes to different resources don't
+// hold each other up.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis connects to the Redis server at url, e.g. redis://localhost:6379/0
+func NewRedis(url string) (*Redis, error) {
+	opts, e := redis.ParseURL(url)
+	if e != nil {
+		return nil, e
+	}
+
+	client := redis.NewClient(opts)
+	e = client.Ping().Err()
+	if e != nil {
+		client.Close()
+		return nil, e
+	}
+
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+func redisResourceKey(key string) string {
+	return redisKeyResource + key
+}
+
+func redisQueueKey(key string) string {
+	return redisKeyQueue + key
+}
+
+// atomically runs f with keys watched, retrying if the transaction fails because a key changed
+func (r *Redis) atomically(f func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < redisMaxRetries; i++ {
+		e := r.client.Watch(f, keys...)
+		if e != redis.TxFailedErr {
+			return e
+		}
+	}
+	return redis.TxFailedErr
+}
+
+func redisGetResource(c redis.Cmdable, key string) (*models.Resource, error) {
+	v, e := c.Get(redisResourceKey(key)).Bytes()
+	if e == redis.Nil {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	res := &models.Resource{}
+	e = json.Unmarshal(v, res)
+	if e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+func redisGetQueue(c redis.Cmdable, res *models.Resource) (*models.Queue, error) {
+	q := &models.Queue{
+		Resource: res,
+	}
+
+	values, e := c.LRange(redisQueueKey(res.Key()), 0, -1).Result()
+	if e != nil {
+		return nil, e
+	}
+	for _, v := range values {
+		reservation := &models.Reservation{}
+		e := json.Unmarshal([]byte(v), reservation)
+		if e != nil {
+			return nil, e
+		}
+		// The resource is implied by the queue so it isn't stored with each reservation
+		reservation.Resource = res
+		q.Reservations = append(q.Reservations, reservation)
+	}
+	return q, nil
+}
+
+func redisGetResources(c redis.Cmdable, filter func(res *models.Resource) bool) ([]*models.Resource, error) {
+	keys, e := c.SMembers(redisKeyResources).Result()
+	if e != nil {
+		return nil, e
+	}
+	sort.Strings(keys)
+
+	ret := []*models.Resource{}
+	if len(keys) == 0 {
+		return ret, nil
+	}
+	stored := []string{}
+	for _, k := range keys {
+		stored = append(stored, redisResourceKey(k))
+	}
+	values, e := c.MGet(stored...).Result()
+	if e != nil {
+		return nil, e
+	}
+
+	for _, v := range values {
+		// The resource was removed after its key was read
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		res := &models.Resource{}
+		e := json.Unmarshal([]byte(s), res)
+		if e != nil {
+			return nil, e
+		}
+		if filter == nil || filter(res) {
+			ret = append(ret, res)
+		}
+	}
+	return ret, nil
+}
+
+func redisPutResource(pipe redis.Pipeliner, res *models.Resource) error {
+	v, e := json.Marshal(res)
+	if e != nil {
+		return e
+	}
+	pipe.Set(redisResourceKey(res.Key()), v, 0)
+	pipe.SAdd(redisKeyResources, res.Key())
+	return nil
+}
+
+// redisDelResource removes a resource and its queue
+func redisDelResource(pipe redis.Pipeliner, key string) {
+	pipe.SRem(redisKeyResources, key)
+	pipe.Del(redisResourceKey(key), redisQueueKey(key))
+}
+
+// redisPutQueue replaces the stored queue with q
+func redisPutQueue(pipe redis.Pipeliner, q *models.Queue) error {
+	key := redisQueueKey(q.Resource.Key())
+	pipe.Del(key)
+
+	values := []interface{}{}
+	for _, res := range q.Reservations {
+		v, e := json.Marshal(res)
+		if e != nil {
+			return e
+		}
+		values = append(values, v)
+	}
+	if len(values) > 0 {
+		pipe.RPush(key, values...)
+	}
+	return nil
+}
+
+// redisRecord appends an event to the history list
+func redisRecord(pipe redis.Pipeliner, ev *models.Event) error {
+	v, e := json.Marshal(ev)
+	if e != nil {
+		return e
+	}
+	pipe.RPush(redisKeyHistory, v)
+	return nil
+}
+
+func (r *Redis) Create(name, env string) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		now := time.Now()
+		created := res == nil
+		if created {
+			res = &models.Resource{
+				Name: name,
+				Env:  env,
+			}
+		}
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutResource(pipe, res)
+			if e != nil || !created {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.ResourceCreated, Name: name, Env: env, Time: now})
+		})
+		return e
+	}, redisResourceKey(key))
+}
+
+func (r *Redis) Reserve(u *models.User, name, env string) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		now := time.Now()
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		created := res == nil
+		if created {
+			res = &models.Resource{
+				Name: name,
+				Env:  env,
+			}
+		}
+
+		q, e := redisGetQueue(tx, res)
+		if e != nil {
+			return e
+		}
+		// check for existing reservation
+		for _, reservation := range q.Reservations {
+			if reservation.User.ID == u.ID {
+				return err.AlreadyInQueue
+			}
+		}
+
+		v, e := json.Marshal(&models.Reservation{
+			User: u,
+			Time: now,
+		})
+		if e != nil {
+			return e
+		}
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			if created {
+				e := redisRecord(pipe, &models.Event{Type: models.ResourceCreated, Name: name, Env: env, Time: now})
+				if e != nil {
+					return e
+				}
+			}
+			e := redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			pipe.RPush(redisQueueKey(key), v)
+			return redisRecord(pipe, &models.Event{Type: models.Reserved, User: u, Name: name, Env: env, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+// watchAll returns the keys of the resources and of their queues, to watch them all at once
+func watchAll(resources []*models.Resource) []string {
+	ret := []string{}
+	for _, res := range resources {
+		ret = append(ret, redisResourceKey(res.Key()), redisQueueKey(res.Key()))
+	}
+	return ret
+}
+
+func (r *Redis) ReserveAll(u *models.User, resources []*models.Resource) error {
+	resources, keys := composite(resources)
+	return r.atomically(func(tx *redis.Tx) error {
+		now := time.Now()
+		queues := []*models.Queue{}
+		created := []*models.Resource{}
+		for _, res := range resources {
+			existing, e := redisGetResource(tx, res.Key())
+			if e != nil {
+				return e
+			}
+			if existing == nil {
+				existing = &models.Resource{
+					Name: res.Name,
+					Env:  res.Env,
+				}
+				created = append(created, existing)
+			}
+			q, e := redisGetQueue(tx, existing)
+			if e != nil {
+				return e
+			}
+			if q.Find(u) != -1 {
+				return err.AlreadyInQueue
+			}
+			existing.LastActivity = now
+			queues = append(queues, q)
+		}
+
+		v, e := json.Marshal(&models.Reservation{
+			User:      u,
+			Time:      now,
+			Composite: keys,
+		})
+		if e != nil {
+			return e
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, res := range created {
+				e := redisRecord(pipe, &models.Event{Type: models.ResourceCreated, Name: res.Name, Env: res.Env, Time: now})
+				if e != nil {
+					return e
+				}
+			}
+			for _, q := range queues {
+				e := redisPutResource(pipe, q.Resource)
+				if e != nil {
+					return e
+				}
+				pipe.RPush(redisQueueKey(q.Resource.Key()), v)
+			}
+			return redisRecord(pipe, &models.Event{Type: models.ReservedAll, User: u, Resources: resources, Time: now})
+		})
+		return e
+	}, watchAll(resources)...)
+}
+
+func (r *Redis) GrantAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return r.atomically(func(tx *redis.Tx) error {
+		queues := []*models.Queue{}
+		for _, res := range resources {
+			existing, e := redisGetResource(tx, res.Key())
+			if e != nil {
+				return e
+			}
+			if existing == nil {
+				return err.ResourceDoesNotExist
+			}
+			q, e := redisGetQueue(tx, existing)
+			if e != nil {
+				return e
+			}
+			idx := q.Find(u)
+			if idx == -1 {
+				return err.NotInQueue
+			}
+			if idx >= len(q.Holders()) || !q.Reservations[idx].Pending() {
+				return err.NotHolder
+			}
+			queues = append(queues, q)
+		}
+
+		now := time.Now()
+		for _, q := range queues {
+			res := q.Reservations[q.Find(u)]
+			res.Composite = nil
+			res.Time = now
+			q.Resource.LastActivity = now
+		}
+
+		_, e := tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, q := range queues {
+				e := redisPutQueue(pipe, q)
+				if e != nil {
+					return e
+				}
+				e = redisPutResource(pipe, q.Resource)
+				if e != nil {
+					return e
+				}
+			}
+			return redisRecord(pipe, &models.Event{Type: models.GrantedAll, User: u, Resources: resources, Time: now})
+		})
+		return e
+	}, watchAll(resources)...)
+}
+
+// UpdateResource changes a resource by calling update with a copy of it while its key is watched. update is
+// called again if another client changed the resource before the change could be committed.
+func (r *Redis) UpdateResource(name, env string, update func(res *models.Resource) error) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		existing, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if existing == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.Name, updated.Env = existing.Name, existing.Env
+		updated.LastActivity = time.Now()
+
+		// Raising the capacity moves the next users in line into the holders
+		var q *models.Queue
+		if updated.Holders() > existing.Holders() {
+			q, e = redisGetQueue(tx, &updated)
+			if e != nil {
+				return e
+			}
+			q.Promote(existing.Holders(), updated.LastActivity)
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutResource(pipe, &updated)
+			if e != nil {
+				return e
+			}
+			if q != nil {
+				e = redisPutQueue(pipe, q)
+				if e != nil {
+					return e
+				}
+			}
+			return redisRecord(pipe, &models.Event{Type: models.ResourceUpdated, Name: name, Env: env, Resource: &updated, Time: updated.LastActivity})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+// UpdateReservation changes the user's reservation for a resource by calling update with a copy of it while
+// the queue is watched, like UpdateResource. The time of the reservation is not changed.
+func (r *Redis) UpdateReservation(u *models.User, name, env string, update func(res *models.Reservation) error) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		resource, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if resource == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := redisGetQueue(tx, resource)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		existing := q.Reservations[idx]
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.User = existing.User
+		updated.Resource = resource
+		updated.Time = existing.Time
+		q.Reservations[idx] = &updated
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutQueue(pipe, q)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.ReservationUpdated, User: updated.User, Name: name, Env: env, Reservation: &updated, Time: time.Now()})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+func (r *Redis) GetReservation(u *models.User, name, env string) *models.Reservation {
+	q, e := r.GetQueueForResource(name, env)
+	if e != nil {
+		if e != err.ResourceDoesNotExist {
+			log.Errorf("%+v", e)
+		}
+		return nil
+	}
+	for _, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return res
+		}
+	}
+	return nil
+}
+
+// Remove removes a user from a resource's queue.
+// If the removal advances the queue, the new resource holder's reservation will have the time updated
+func (r *Redis) Remove(u *models.User, name, env string) error {
+	return r.remove(u, name, env, models.Removed)
+}
+
+// Kick removes a user from a resource's queue on behalf of someone else
+func (r *Redis) Kick(u *models.User, name, env string) error {
+	return r.remove(u, name, env, models.Kicked)
+}
+
+func (r *Redis) remove(u *models.User, name, env string, t models.EventType) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if res == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := redisGetQueue(tx, res)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, reservation := range q.Reservations {
+			if reservation.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		evType := t
+		length := q.HeldFor(idx, now)
+		if q.Remove(idx, now) && t == models.Removed {
+			evType = models.Released
+		}
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutQueue(pipe, q)
+			if e != nil {
+				return e
+			}
+			e = redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: evType, User: u, Name: name, Env: env, Held: length, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+// Move moves a user to pos in a resource's queue, where 1 is the front. Users who become holders because of
+// the move will have the time on their reservation updated.
+func (r *Redis) Move(u *models.User, name, env string, pos int) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if res == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := redisGetQueue(tx, res)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, reservation := range q.Reservations {
+			if reservation.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Move(idx, pos-1, now)
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutQueue(pipe, q)
+			if e != nil {
+				return e
+			}
+			e = redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.Moved, User: u, Name: name, Env: env, Position: pos, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+// Swap swaps the places of two users in a resource's queue. Users who become holders because of the swap
+// will have the time on their reservation updated.
+func (r *Redis) Swap(a, b *models.User, name, env string) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if res == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := redisGetQueue(tx, res)
+		if e != nil {
+			return e
+		}
+
+		i, j := q.Find(a), q.Find(b)
+		if i == -1 || j == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Swap(i, j, now)
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutQueue(pipe, q)
+			if e != nil {
+				return e
+			}
+			e = redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.Swapped, User: a, Target: b, Name: name, Env: env, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+// Handoff hands a resource the user holds over to another user, who takes their place among the holders
+// and starts holding it now. The other user's place in the queue, if they had one, is given up.
+func (r *Redis) Handoff(u, to *models.User, name, env string) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if res == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := redisGetQueue(tx, res)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		if idx >= len(q.Holders()) {
+			return err.NotHolder
+		}
+
+		now := time.Now()
+		length := q.HeldFor(idx, now)
+		q.Handoff(idx, to, now)
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			e := redisPutQueue(pipe, q)
+			if e != nil {
+				return e
+			}
+			e = redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.HandedOff, User: u, Target: to, Name: name, Env: env, Held: length, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+func (r *Redis) GetPosition(u *models.User, name, env string) (int, error) {
+	q, e := r.GetQueueForResource(name, env)
+	if e != nil {
+		return 0, e
+	}
+	for i, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return i + 1, nil
+		}
+	}
+	return 0, err.NotInQueue
+}
+
+func (r *Redis) GetResource(name, env string, create bool) *models.Resource {
+	key := models.ResourceKey(name, env)
+	res, e := redisGetResource(r.client, key)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return nil
+	}
+	if res != nil || !create {
+		return res
+	}
+
+	e = r.Create(name, env)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return nil
+	}
+	res, e = redisGetResource(r.client, key)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return nil
+	}
+	return res
+}
+
+func (r *Redis) RemoveResource(name, env string) error {
+	return r.removeResource(name, env, models.ResourceRemoved)
+}
+
+func (r *Redis) removeResource(name, env string, t models.EventType) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		exists, e := tx.Exists(redisResourceKey(key)).Result()
+		if e != nil {
+			return e
+		}
+		if exists == 0 {
+			return err.ResourceDoesNotExist
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			redisDelResource(pipe, key)
+			return redisRecord(pipe, &models.Event{Type: t, Name: name, Env: env, Time: time.Now()})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+func (r *Redis) RemoveEnv(name, env string) error {
+	return r.atomically(func(tx *redis.Tx) error {
+		resources, e := redisGetResources(tx, func(res *models.Resource) bool {
+			return res.Env == env
+		})
+		if e != nil {
+			return e
+		}
+		if len(resources) == 0 {
+			return err.EnvDoesNotExist
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, res := range resources {
+				redisDelResource(pipe, res.Key())
+			}
+			return redisRecord(pipe, &models.Event{Type: models.EnvRemoved, Name: name, Env: env, Time: time.Now()})
+		})
+		return e
+	}, redisKeyResources)
+}
+
+func (r *Redis) GetResources() []*models.Resource {
+	ret, e := redisGetResources(r.client, nil)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (r *Redis) GetQueues() []*models.Queue {
+	ret := []*models.Queue{}
+	for _, res := range r.GetResources() {
+		q, e := redisGetQueue(r.client, res)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret = append(ret, q)
+	}
+	return ret
+}
+
+func (r *Redis) GetQueueForResource(name, env string) (*models.Queue, error) {
+	res, e := redisGetResource(r.client, models.ResourceKey(name, env))
+	if e != nil {
+		return nil, e
+	}
+	if res == nil {
+		return nil, err.ResourceDoesNotExist
+	}
+	return redisGetQueue(r.client, res)
+}
+
+func (r *Redis) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	q, e := r.GetQueueForResource(name, env)
+	if e != nil {
+		return nil, e
+	}
+	if !q.HasReservations() {
+		return nil, nil
+	}
+	return q.Reservations[0], nil
+}
+
+func (r *Redis) GetQueuesForEnv(env string) map[string]*models.Queue {
+	ret := make(map[string]*models.Queue)
+	for _, res := range r.GetResourcesForEnv(env) {
+		q, e := redisGetQueue(r.client, res)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret[res.Name] = q
+	}
+	return ret
+}
+
+func (r *Redis) GetResourcesForEnv(env string) []*models.Resource {
+	ret, e := redisGetResources(r.client, func(res *models.Resource) bool {
+		return res.Env == env
+	})
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (r *Redis) GetAllUsersInQueues() []*models.User {
+	all := map[string]*models.User{}
+	for _, q := range r.GetQueues() {
+		for _, res := range q.Reservations {
+			all[res.User.ID] = res.User
+		}
+	}
+
+	ret := []*models.User{}
+	for _, u := range all {
+		ret = append(ret, u)
+	}
+	return ret
+}
+
+func (r *Redis) ClearQueueForResource(name, env string) error {
+	key := models.ResourceKey(name, env)
+	return r.atomically(func(tx *redis.Tx) error {
+		res, e := redisGetResource(tx, key)
+		if e != nil {
+			return e
+		}
+		if res == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		now := time.Now()
+		res.LastActivity = now
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(redisQueueKey(key))
+			e := redisPutResource(pipe, res)
+			if e != nil {
+				return e
+			}
+			return redisRecord(pipe, &models.Event{Type: models.Cleared, Name: name, Env: env, Time: now})
+		})
+		return e
+	}, redisResourceKey(key), redisQueueKey(key))
+}
+
+func (r *Redis) PruneInactiveResources(hours int) error {
+	return pruneInactiveResources(r, hours, func(name, env string) error {
+		return r.removeResource(name, env, models.Pruned)
+	})
+}
+
+// Nuke removes all resources and queues. History is kept.
+func (r *Redis) Nuke() error {
+	return r.atomically(func(tx *redis.Tx) error {
+		keys, e := tx.SMembers(redisKeyResources).Result()
+		if e != nil {
+			return e
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, k := range keys {
+				redisDelResource(pipe, k)
+			}
+			return redisRecord(pipe, &models.Event{Type: models.Nuked, Time: time.Now()})
+		})
+		return e
+	}, redisKeyResources)
+}
+
+func (r *Redis) AddBooking(b *models.Booking) error {
+	return r.putBooking(b, models.BookingAdded)
+}
+
+// UpdateBooking replaces the attributes of the booking with the same ID as b
+func (r *Redis) UpdateBooking(b *models.Booking) error {
+	return r.putBooking(b, models.BookingUpdated)
+}
+
+func (r *Redis) putBooking(b *models.Booking, t models.EventType) error {
+	return r.atomically(func(tx *redis.Tx) error {
+		if t == models.BookingUpdated {
+			exists, e := tx.HExists(redisKeyBookings, b.ID).Result()
+			if e != nil {
+				return e
+			}
+			if !exists {
+				return err.BookingDoesNotExist
+			}
+		}
+
+		v, e := json.Marshal(b)
+		if e != nil {
+			return e
+		}
+		c := *b
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.HSet(redisKeyBookings, b.ID, v)
+			return redisRecord(pipe, &models.Event{Type: t, User: b.User, Name: b.Name, Env: b.Env, Booking: &c, Time: time.Now()})
+		})
+		return e
+	}, redisKeyBookings)
+}
+
+func (r *Redis) RemoveBooking(id string) error {
+	return r.atomically(func(tx *redis.Tx) error {
+		exists, e := tx.HExists(redisKeyBookings, id).Result()
+		if e != nil {
+			return e
+		}
+		if !exists {
+			return err.BookingDoesNotExist
+		}
+
+		_, e = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.HDel(redisKeyBookings, id)
+			return redisRecord(pipe, &models.Event{Type: models.BookingRemoved, Booking: &models.Booking{ID: id}, Time: time.Now()})
+		})
+		return e
+	}, redisKeyBookings)
+}
+
+// GetBookings returns all bookings, ordered by start
+func (r *Redis) GetBookings() []*models.Booking {
+	ret := []*models.Booking{}
+
+	all, e := r.client.HGetAll(redisKeyBookings).Result()
+	if e != nil {
+		log.Errorf("%+v", e)
+		return ret
+	}
+	for _, v := range all {
+		b := &models.Booking{}
+		e := json.Unmarshal([]byte(v), b)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret = append(ret, b)
+	}
+	sortBookings(ret)
+	return ret
+}
+
+func (r *Redis) GetEvents(since time.Time) []*models.Event {
+	ret := []*models.Event{}
+
+	n, e := r.client.LLen(redisKeyHistory).Result()
+	if e != nil {
+		log.Errorf("%+v", e)
+		return ret
+	}
+
+	// Events are stored in the order they happened, so they are read a page at a time from the newest until
+	// one is older than since. Indexes from the start stay put while new events are appended.
+	const page = 100
+	for end := n - 1; end >= 0; end -= page {
+		start := end - page + 1
+		if start < 0 {
+			start = 0
+		}
+		values, e := r.client.LRange(redisKeyHistory, start, end).Result()
+		if e != nil {
+			log.Errorf("%+v", e)
+			break
+		}
+		for i := len(values) - 1; i >= 0; i-- {
+			ev := &models.Event{}
+			e := json.Unmarshal([]byte(values[i]), ev)
+			if e != nil {
+				log.Errorf("%+v", e)
+				continue
+			}
+			if ev.Time.Before(since) {
+				reverseEvents(ret)
+				return ret
+			}
+			ret = append(ret, ev)
+		}
+	}
+	reverseEvents(ret)
+	return ret
+}
@@ -0,0 +1,1201 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migrations are applied in order and recorded in schema_migrations. Never edit a migration that has
+// been released, add a new one instead.
+var migrations = []string{
+	// 1: resources, reservations and history
+	`CREATE TABLE resources (
+		env TEXT NOT NULL,
+		name TEXT NOT NULL,
+		last_activity TIMESTAMP NOT NULL,
+		PRIMARY KEY (env, name)
+	);
+	CREATE TABLE reservations (
+		env TEXT NOT NULL,
+		name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		seq BIGINT NOT NULL,
+		reserved_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (env, name, user_id)
+	);
+	CREATE TABLE history (
+		op TEXT NOT NULL,
+		env TEXT NOT NULL,
+		name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX history_at ON history (at);`,
+	// 2: attributes beyond the core columns are stored as JSON in data
+	`ALTER TABLE resources ADD COLUMN data TEXT NOT NULL DEFAULT '';
+	ALTER TABLE reservations ADD COLUMN data TEXT NOT NULL DEFAULT '';
+	ALTER TABLE history ADD COLUMN data TEXT NOT NULL DEFAULT '';`,
+	// 3: bookings
+	`CREATE TABLE bookings (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);`,
+	// 4: history ids, so that events at the same time keep their order. SQLite can't add an autoincrement
+	// column to an existing table, so it is rebuilt.
+	`CREATE TABLE history_ids (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		op TEXT NOT NULL,
+		env TEXT NOT NULL,
+		name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		at TIMESTAMP NOT NULL,
+		data TEXT NOT NULL DEFAULT ''
+	);
+	INSERT INTO history_ids (op, env, name, user_id, user_name, at, data)
+		SELECT op, env, name, user_id, user_name, at, data FROM history ORDER BY rowid;
+	DROP TABLE history;
+	ALTER TABLE history_ids RENAME TO history;
+	CREATE INDEX history_at ON history (at);`,
+}
+
+// postgresMigrations replace the migrations of the same version on Postgres, where the schema can use types
+// SQLite doesn't have
+var postgresMigrations = map[int]string{
+	// 4: history ids, and timestamps with time zones. Times stored so far are taken to be in the time zone
+	// of the session.
+	4: `ALTER TABLE history ADD COLUMN id BIGSERIAL;
+	ALTER TABLE resources ALTER COLUMN last_activity TYPE TIMESTAMPTZ;
+	ALTER TABLE reservations ALTER COLUMN reserved_at TYPE TIMESTAMPTZ;
+	ALTER TABLE history ALTER COLUMN at TYPE TIMESTAMPTZ;`,
+}
+
+// SQL is a Manager backed by a relational database. SQLite is used for a plain file path DSN and
+// Postgres for a postgres:// DSN. The same queries are used for both.
+type SQL struct {
+	db       *sql.DB
+	postgres bool
+}
+
+func NewSQL(dsn string) (*SQL, error) {
+	driver := "sqlite"
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		driver = "postgres"
+	}
+
+	db, e := sql.Open(driver, dsn)
+	if e != nil {
+		return nil, e
+	}
+	if driver == "sqlite" {
+		// SQLite only allows a single writer
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQL{
+		db:       db,
+		postgres: driver == "postgres",
+	}
+
+	e = s.migrate()
+	if e != nil {
+		db.Close()
+		return nil, e
+	}
+
+	return s, nil
+}
+
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies all migrations that have not been applied yet
+func (s *SQL) migrate() error {
+	_, e := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if e != nil {
+		return e
+	}
+
+	current := 0
+	e = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if e != nil {
+		return e
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		migration := migrations[i]
+		if m, ok := postgresMigrations[version]; ok && s.postgres {
+			migration = m
+		}
+		e := s.tx(func(tx *sql.Tx) error {
+			// Not every driver supports multiple statements in a single Exec
+			for _, stmt := range strings.Split(migration, ";") {
+				if strings.TrimSpace(stmt) == "" {
+					continue
+				}
+				_, e := tx.Exec(stmt)
+				if e != nil {
+					return e
+				}
+			}
+			_, e := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version)
+			return e
+		})
+		if e != nil {
+			return fmt.Errorf("applying migration %d: %w", version, e)
+		}
+		log.Infof("Applied migration %d", version)
+	}
+
+	return nil
+}
+
+// rebind converts ? placeholders into the $n form Postgres expects
+func (s *SQL) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// tx runs f in a transaction, committing if it returns nil and rolling back otherwise
+func (s *SQL) tx(f func(tx *sql.Tx) error) error {
+	tx, e := s.db.Begin()
+	if e != nil {
+		return e
+	}
+	e = f(tx)
+	if e != nil {
+		tx.Rollback()
+		return e
+	}
+	return tx.Commit()
+}
+
+// isUniqueViolation returns whether an error is a violation of a primary key or unique constraint
+func isUniqueViolation(e error) bool {
+	var pe *pq.Error
+	if errors.As(e, &pe) {
+		return pe.Code == "23505"
+	}
+	var se *sqlite.Error
+	if errors.As(e, &se) {
+		return se.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
+
+// encodeData returns the JSON stored in a data column
+func encodeData(v interface{}) (string, error) {
+	b, e := json.Marshal(v)
+	if e != nil {
+		return "", e
+	}
+	return string(b), nil
+}
+
+// decodeData reads a data column into v. Rows written before data was added have an empty column.
+func decodeData(data string, v interface{}) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *SQL) getResource(q querier, name, env string) (*models.Resource, error) {
+	return s.scanResource(q, `SELECT last_activity, data FROM resources WHERE env = ? AND name = ?`, name, env)
+}
+
+// lockResource returns a resource like getResource and, on Postgres, locks its row until the transaction
+// ends, so that changes to its queue are made one at a time. SQLite only allows a single writer anyway.
+func (s *SQL) lockResource(q querier, name, env string) (*models.Resource, error) {
+	query := `SELECT last_activity, data FROM resources WHERE env = ? AND name = ?`
+	if s.postgres {
+		query += ` FOR UPDATE`
+	}
+	return s.scanResource(q, query, name, env)
+}
+
+func (s *SQL) scanResource(q querier, query, name, env string) (*models.Resource, error) {
+	r := &models.Resource{}
+	data := ""
+	la := time.Time{}
+	e := q.QueryRow(s.rebind(query), env, name).Scan(&la, &data)
+	if e == sql.ErrNoRows {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	e = decodeData(data, r)
+	if e != nil {
+		return nil, e
+	}
+	r.Name, r.Env, r.LastActivity = name, env, la
+	return r, nil
+}
+
+// resource returns the resource for name and env, creating it when create is set. Its row is locked as by
+// lockResource.
+func (s *SQL) resource(q querier, name, env string, create bool, t time.Time) (*models.Resource, error) {
+	r, e := s.lockResource(q, name, env)
+	if e != nil || r != nil || !create {
+		return r, e
+	}
+
+	r = &models.Resource{
+		Name:         name,
+		Env:          env,
+		LastActivity: t,
+	}
+	data, e := encodeData(r)
+	if e != nil {
+		return nil, e
+	}
+	res, e := q.Exec(s.rebind(`INSERT INTO resources (env, name, last_activity, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (env, name) DO NOTHING`), env, name, t, data)
+	if e != nil {
+		return nil, e
+	}
+	n, e := res.RowsAffected()
+	if e != nil {
+		return nil, e
+	}
+	if n == 0 {
+		// It was created by someone else in the meantime
+		return s.lockResource(q, name, env)
+	}
+	return r, s.record(q, &models.Event{Type: models.ResourceCreated, Name: name, Env: env, Time: t})
+}
+
+func (s *SQL) touch(q querier, r *models.Resource, t time.Time) error {
+	r.LastActivity = t
+	_, e := q.Exec(s.rebind(`UPDATE resources SET last_activity = ? WHERE env = ? AND name = ?`), t, r.Env, r.Name)
+	return e
+}
+
+func (s *SQL) resources(q querier, where string, args ...interface{}) ([]*models.Resource, error) {
+	rows, e := q.Query(s.rebind(`SELECT env, name, last_activity, data FROM resources `+where+` ORDER BY env, name`), args...)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	ret := []*models.Resource{}
+	for rows.Next() {
+		r := &models.Resource{}
+		env, name, data := "", "", ""
+		la := time.Time{}
+		e := rows.Scan(&env, &name, &la, &data)
+		if e != nil {
+			return nil, e
+		}
+		e = decodeData(data, r)
+		if e != nil {
+			return nil, e
+		}
+		r.Name, r.Env, r.LastActivity = name, env, la
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQL) queue(q querier, r *models.Resource) (*models.Queue, error) {
+	ret := &models.Queue{
+		Resource: r,
+	}
+
+	rows, e := q.Query(s.rebind(`SELECT user_id, user_name, reserved_at, data FROM reservations WHERE env = ? AND name = ? ORDER BY seq`), r.Env, r.Name)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		res := &models.Reservation{}
+		u := &models.User{}
+		data := ""
+		t := time.Time{}
+		e := rows.Scan(&u.ID, &u.Name, &t, &data)
+		if e != nil {
+			return nil, e
+		}
+		e = decodeData(data, res)
+		if e != nil {
+			return nil, e
+		}
+		res.User, res.Resource, res.Time = u, r, t
+		ret.Reservations = append(ret.Reservations, res)
+	}
+	return ret, rows.Err()
+}
+
+// appendReservation adds a reservation to the end of a resource's queue. The row of the resource must be
+// locked, so that nobody else can take the same seq.
+func (s *SQL) appendReservation(q querier, r *models.Resource, u *models.User, t time.Time, data string) error {
+	reservedAt := "?"
+	if s.postgres {
+		// Postgres can't tell the type of a placeholder in a SELECT list from the column it is inserted into,
+		// and takes it to be text
+		reservedAt = "CAST(? AS TIMESTAMPTZ)"
+	}
+	_, e := q.Exec(s.rebind(`INSERT INTO reservations (env, name, user_id, user_name, seq, reserved_at, data)
+		SELECT ?, ?, ?, ?, COALESCE(MAX(seq), 0) + 1, `+reservedAt+`, ? FROM reservations WHERE env = ? AND name = ?`),
+		r.Env, r.Name, u.ID, u.Name, t, data, r.Env, r.Name)
+	return e
+}
+
+// record appends an event to the history table
+func (s *SQL) record(q querier, ev *models.Event) error {
+	uid, uname := "", ""
+	if ev.User != nil {
+		uid, uname = ev.User.ID, ev.User.Name
+	}
+	data, e := encodeData(ev)
+	if e != nil {
+		return e
+	}
+	_, e = q.Exec(s.rebind(`INSERT INTO history (op, env, name, user_id, user_name, at, data) VALUES (?, ?, ?, ?, ?, ?, ?)`), string(ev.Type), ev.Env, ev.Name, uid, uname, ev.Time, data)
+	return e
+}
+
+func (s *SQL) Create(name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		now := time.Now()
+		r, e := s.resource(tx, name, env, true, now)
+		if e != nil {
+			return e
+		}
+		return s.touch(tx, r, now)
+	})
+}
+
+func (s *SQL) Reserve(u *models.User, name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		now := time.Now()
+		r, e := s.resource(tx, name, env, true, now)
+		if e != nil {
+			return e
+		}
+
+		// check for existing reservation
+		count := 0
+		e = tx.QueryRow(s.rebind(`SELECT COUNT(*) FROM reservations WHERE env = ? AND name = ? AND user_id = ?`), env, name, u.ID).Scan(&count)
+		if e != nil {
+			return e
+		}
+		if count > 0 {
+			return err.AlreadyInQueue
+		}
+
+		data, e := encodeData(&models.Reservation{
+			User: u,
+			Time: now,
+		})
+		if e != nil {
+			return e
+		}
+		e = s.appendReservation(tx, r, u, now, data)
+		if isUniqueViolation(e) {
+			return err.AlreadyInQueue
+		}
+		if e != nil {
+			return e
+		}
+
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.Reserved, User: u, Name: name, Env: env, Time: now})
+	})
+}
+
+func (s *SQL) ReserveAll(u *models.User, resources []*models.Resource) error {
+	resources, keys := composite(resources)
+	return s.tx(func(tx *sql.Tx) error {
+		now := time.Now()
+		// The resources are locked in key order, so that two requests for the same ones can't deadlock
+		locked := []*models.Resource{}
+		for _, r := range resources {
+			existing, e := s.resource(tx, r.Name, r.Env, true, now)
+			if e != nil {
+				return e
+			}
+
+			count := 0
+			e = tx.QueryRow(s.rebind(`SELECT COUNT(*) FROM reservations WHERE env = ? AND name = ? AND user_id = ?`), r.Env, r.Name, u.ID).Scan(&count)
+			if e != nil {
+				return e
+			}
+			if count > 0 {
+				return err.AlreadyInQueue
+			}
+			locked = append(locked, existing)
+		}
+
+		data, e := encodeData(&models.Reservation{
+			User:      u,
+			Time:      now,
+			Composite: keys,
+		})
+		if e != nil {
+			return e
+		}
+		for _, r := range locked {
+			e = s.appendReservation(tx, r, u, now, data)
+			if isUniqueViolation(e) {
+				return err.AlreadyInQueue
+			}
+			if e != nil {
+				return e
+			}
+
+			e = s.touch(tx, r, now)
+			if e != nil {
+				return e
+			}
+		}
+
+		return s.record(tx, &models.Event{Type: models.ReservedAll, User: u, Resources: resources, Time: now})
+	})
+}
+
+func (s *SQL) GrantAll(u *models.User, resources []*models.Resource) error {
+	resources, _ = composite(resources)
+	return s.tx(func(tx *sql.Tx) error {
+		pending := []*models.Reservation{}
+		for _, r := range resources {
+			existing, e := s.lockResource(tx, r.Name, r.Env)
+			if e != nil {
+				return e
+			}
+			if existing == nil {
+				return err.ResourceDoesNotExist
+			}
+			q, e := s.queue(tx, existing)
+			if e != nil {
+				return e
+			}
+			idx := q.Find(u)
+			if idx == -1 {
+				return err.NotInQueue
+			}
+			if idx >= len(q.Holders()) || !q.Reservations[idx].Pending() {
+				return err.NotHolder
+			}
+			pending = append(pending, q.Reservations[idx])
+		}
+
+		now := time.Now()
+		for _, res := range pending {
+			res.Composite = nil
+			res.Time = now
+			data, e := encodeData(res)
+			if e != nil {
+				return e
+			}
+			_, e = tx.Exec(s.rebind(`UPDATE reservations SET reserved_at = ?, data = ? WHERE env = ? AND name = ? AND user_id = ?`), now, data, res.Resource.Env, res.Resource.Name, u.ID)
+			if e != nil {
+				return e
+			}
+
+			e = s.touch(tx, res.Resource, now)
+			if e != nil {
+				return e
+			}
+		}
+
+		return s.record(tx, &models.Event{Type: models.GrantedAll, User: u, Resources: resources, Time: now})
+	})
+}
+
+// UpdateResource changes a resource by calling update with a copy of it in a transaction that locks its row
+func (s *SQL) UpdateResource(name, env string, update func(r *models.Resource) error) error {
+	return s.tx(func(tx *sql.Tx) error {
+		existing, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if existing == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.Name, updated.Env = name, env
+		updated.LastActivity = time.Now()
+		data, e := encodeData(&updated)
+		if e != nil {
+			return e
+		}
+
+		res, e := tx.Exec(s.rebind(`UPDATE resources SET last_activity = ?, data = ? WHERE env = ? AND name = ?`), updated.LastActivity, data, env, name)
+		if e != nil {
+			return e
+		}
+		n, e := res.RowsAffected()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			return err.ResourceDoesNotExist
+		}
+
+		// Raising the capacity moves the next users in line into the holders
+		if updated.Holders() > existing.Holders() {
+			q, e := s.queue(tx, &updated)
+			if e != nil {
+				return e
+			}
+			holders := q.Holders()
+			for i := existing.Holders(); i < len(holders); i++ {
+				res := holders[i]
+				_, e = tx.Exec(s.rebind(`UPDATE reservations SET reserved_at = ? WHERE env = ? AND name = ? AND user_id = ?`), updated.LastActivity, env, name, res.User.ID)
+				if e != nil {
+					return e
+				}
+			}
+		}
+
+		return s.record(tx, &models.Event{Type: models.ResourceUpdated, Name: name, Env: env, Resource: &updated, Time: updated.LastActivity})
+	})
+}
+
+// UpdateReservation changes the user's reservation for a resource by calling update with a copy of it in a
+// transaction that locks the row of the resource. The time of the reservation is not changed.
+func (s *SQL) UpdateReservation(u *models.User, name, env string, update func(res *models.Reservation) error) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := s.queue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		existing := q.Reservations[idx]
+		updated := *existing
+		e = update(&updated)
+		if e != nil {
+			return e
+		}
+		updated.User = existing.User
+		updated.Resource = r
+		updated.Time = existing.Time
+		data, e := encodeData(&updated)
+		if e != nil {
+			return e
+		}
+		_, e = tx.Exec(s.rebind(`UPDATE reservations SET data = ? WHERE env = ? AND name = ? AND user_id = ?`), data, env, name, u.ID)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.ReservationUpdated, User: updated.User, Name: name, Env: env, Reservation: &updated, Time: time.Now()})
+	})
+}
+
+func (s *SQL) GetReservation(u *models.User, name, env string) *models.Reservation {
+	q, e := s.GetQueueForResource(name, env)
+	if e != nil {
+		if e != err.ResourceDoesNotExist {
+			log.Errorf("%+v", e)
+		}
+		return nil
+	}
+	for _, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return res
+		}
+	}
+	return nil
+}
+
+// Remove removes a user from a resource's queue.
+// If the removal advances the queue, the new resource holder's reservation will have the time updated
+func (s *SQL) Remove(u *models.User, name, env string) error {
+	return s.remove(u, name, env, models.Removed)
+}
+
+// Kick removes a user from a resource's queue on behalf of someone else
+func (s *SQL) Kick(u *models.User, name, env string) error {
+	return s.remove(u, name, env, models.Kicked)
+}
+
+func (s *SQL) remove(u *models.User, name, env string, t models.EventType) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := s.queue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		_, e = tx.Exec(s.rebind(`DELETE FROM reservations WHERE env = ? AND name = ? AND user_id = ?`), env, name, u.ID)
+		if e != nil {
+			return e
+		}
+
+		length := q.HeldFor(idx, now)
+		held := q.Remove(idx, now)
+		if held && len(q.Reservations) >= r.Holders() {
+			next := q.Reservations[r.Holders()-1]
+			_, e = tx.Exec(s.rebind(`UPDATE reservations SET reserved_at = ? WHERE env = ? AND name = ? AND user_id = ?`), now, env, name, next.User.ID)
+			if e != nil {
+				return e
+			}
+		}
+
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		if t == models.Removed && held {
+			t = models.Released
+		}
+		return s.record(tx, &models.Event{Type: t, User: u, Name: name, Env: env, Held: length, Time: now})
+	})
+}
+
+// Move moves a user to pos in a resource's queue, where 1 is the front. Users who become holders because of
+// the move will have the time on their reservation updated.
+func (s *SQL) Move(u *models.User, name, env string, pos int) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := s.queue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := -1
+		for i, res := range q.Reservations {
+			if res.User.ID == u.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Move(idx, pos-1, now)
+		e = s.reorder(tx, q)
+		if e != nil {
+			return e
+		}
+
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.Moved, User: u, Name: name, Env: env, Position: pos, Time: now})
+	})
+}
+
+// Swap swaps the places of two users in a resource's queue. Users who become holders because of the swap
+// will have the time on their reservation updated.
+func (s *SQL) Swap(a, b *models.User, name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := s.queue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		i, j := q.Find(a), q.Find(b)
+		if i == -1 || j == -1 {
+			return err.NotInQueue
+		}
+
+		now := time.Now()
+		q.Swap(i, j, now)
+		e = s.reorder(tx, q)
+		if e != nil {
+			return e
+		}
+
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.Swapped, User: a, Target: b, Name: name, Env: env, Time: now})
+	})
+}
+
+// Handoff hands a resource the user holds over to another user, who takes their place among the holders
+// and starts holding it now. The other user's place in the queue, if they had one, is given up.
+func (s *SQL) Handoff(u, to *models.User, name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+		q, e := s.queue(tx, r)
+		if e != nil {
+			return e
+		}
+
+		idx := q.Find(u)
+		if idx == -1 {
+			return err.NotInQueue
+		}
+		if idx >= len(q.Holders()) {
+			return err.NotHolder
+		}
+
+		now := time.Now()
+		length := q.HeldFor(idx, now)
+		q.Handoff(idx, to, now)
+		_, e = tx.Exec(s.rebind(`DELETE FROM reservations WHERE env = ? AND name = ? AND user_id IN (?, ?)`), env, name, u.ID, to.ID)
+		if e != nil {
+			return e
+		}
+		data, e := encodeData(q.Reservations[q.Find(to)])
+		if e != nil {
+			return e
+		}
+		_, e = tx.Exec(s.rebind(`INSERT INTO reservations (env, name, user_id, user_name, seq, reserved_at, data) VALUES (?, ?, ?, ?, 0, ?, ?)`), env, name, to.ID, to.Name, now, data)
+		if e != nil {
+			return e
+		}
+		e = s.reorder(tx, q)
+		if e != nil {
+			return e
+		}
+
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.HandedOff, User: u, Target: to, Name: name, Env: env, Held: length, Time: now})
+	})
+}
+
+// reorder stores the order of a queue and the times of its reservations
+func (s *SQL) reorder(q querier, queue *models.Queue) error {
+	r := queue.Resource
+	for i, res := range queue.Reservations {
+		_, e := q.Exec(s.rebind(`UPDATE reservations SET seq = ?, reserved_at = ? WHERE env = ? AND name = ? AND user_id = ?`), i+1, res.Time, r.Env, r.Name, res.User.ID)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *SQL) GetPosition(u *models.User, name, env string) (int, error) {
+	q, e := s.GetQueueForResource(name, env)
+	if e != nil {
+		return 0, e
+	}
+	for i, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return i + 1, nil
+		}
+	}
+	return 0, err.NotInQueue
+}
+
+func (s *SQL) GetResource(name, env string, create bool) *models.Resource {
+	var r *models.Resource
+	var e error
+	if create {
+		e = s.tx(func(tx *sql.Tx) error {
+			r, e = s.resource(tx, name, env, true, time.Now())
+			return e
+		})
+	} else {
+		r, e = s.getResource(s.db, name, env)
+	}
+	if e != nil {
+		log.Errorf("%+v", e)
+		return nil
+	}
+	return r
+}
+
+func (s *SQL) RemoveResource(name, env string) error {
+	return s.removeResource(name, env, models.ResourceRemoved)
+}
+
+func (s *SQL) removeResource(name, env string, t models.EventType) error {
+	return s.tx(func(tx *sql.Tx) error {
+		res, e := tx.Exec(s.rebind(`DELETE FROM resources WHERE env = ? AND name = ?`), env, name)
+		if e != nil {
+			return e
+		}
+		n, e := res.RowsAffected()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			return err.ResourceDoesNotExist
+		}
+
+		_, e = tx.Exec(s.rebind(`DELETE FROM reservations WHERE env = ? AND name = ?`), env, name)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: t, Name: name, Env: env, Time: time.Now()})
+	})
+}
+
+func (s *SQL) RemoveEnv(name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		res, e := tx.Exec(s.rebind(`DELETE FROM resources WHERE env = ?`), env)
+		if e != nil {
+			return e
+		}
+		n, e := res.RowsAffected()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			return err.EnvDoesNotExist
+		}
+
+		_, e = tx.Exec(s.rebind(`DELETE FROM reservations WHERE env = ?`), env)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.EnvRemoved, Name: name, Env: env, Time: time.Now()})
+	})
+}
+
+func (s *SQL) GetResources() []*models.Resource {
+	ret, e := s.resources(s.db, "")
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (s *SQL) GetQueues() []*models.Queue {
+	ret := []*models.Queue{}
+	for _, r := range s.GetResources() {
+		q, e := s.queue(s.db, r)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret = append(ret, q)
+	}
+	return ret
+}
+
+func (s *SQL) GetQueueForResource(name, env string) (*models.Queue, error) {
+	r, e := s.getResource(s.db, name, env)
+	if e != nil {
+		return nil, e
+	}
+	if r == nil {
+		return nil, err.ResourceDoesNotExist
+	}
+	return s.queue(s.db, r)
+}
+
+func (s *SQL) GetReservationForResource(name, env string) (*models.Reservation, error) {
+	q, e := s.GetQueueForResource(name, env)
+	if e != nil {
+		return nil, e
+	}
+	if !q.HasReservations() {
+		return nil, nil
+	}
+	return q.Reservations[0], nil
+}
+
+func (s *SQL) GetQueuesForEnv(env string) map[string]*models.Queue {
+	ret := make(map[string]*models.Queue)
+	for _, r := range s.GetResourcesForEnv(env) {
+		q, e := s.queue(s.db, r)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret[r.Name] = q
+	}
+	return ret
+}
+
+func (s *SQL) GetResourcesForEnv(env string) []*models.Resource {
+	ret, e := s.resources(s.db, "WHERE env = ?", env)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return []*models.Resource{}
+	}
+	return ret
+}
+
+func (s *SQL) GetAllUsersInQueues() []*models.User {
+	ret := []*models.User{}
+
+	rows, e := s.db.Query(`SELECT DISTINCT user_id, user_name FROM reservations`)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := &models.User{}
+		e := rows.Scan(&u.ID, &u.Name)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret = append(ret, u)
+	}
+	return ret
+}
+
+func (s *SQL) ClearQueueForResource(name, env string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		r, e := s.lockResource(tx, name, env)
+		if e != nil {
+			return e
+		}
+		if r == nil {
+			return err.ResourceDoesNotExist
+		}
+
+		_, e = tx.Exec(s.rebind(`DELETE FROM reservations WHERE env = ? AND name = ?`), env, name)
+		if e != nil {
+			return e
+		}
+
+		now := time.Now()
+		e = s.touch(tx, r, now)
+		if e != nil {
+			return e
+		}
+
+		return s.record(tx, &models.Event{Type: models.Cleared, Name: name, Env: env, Time: now})
+	})
+}
+
+func (s *SQL) PruneInactiveResources(hours int) error {
+	return pruneInactiveResources(s, hours, func(name, env string) error {
+		return s.removeResource(name, env, models.Pruned)
+	})
+}
+
+// Nuke removes all resources and reservations. History is kept.
+func (s *SQL) Nuke() error {
+	return s.tx(func(tx *sql.Tx) error {
+		_, e := tx.Exec(`DELETE FROM reservations`)
+		if e != nil {
+			return e
+		}
+		_, e = tx.Exec(`DELETE FROM resources`)
+		if e != nil {
+			return e
+		}
+		return s.record(tx, &models.Event{Type: models.Nuked, Time: time.Now()})
+	})
+}
+
+func (s *SQL) AddBooking(b *models.Booking) error {
+	return s.tx(func(tx *sql.Tx) error {
+		data, e := encodeData(b)
+		if e != nil {
+			return e
+		}
+		_, e = tx.Exec(s.rebind(`INSERT INTO bookings (id, data) VALUES (?, ?)`), b.ID, data)
+		if e != nil {
+			return e
+		}
+		c := *b
+		return s.record(tx, &models.Event{Type: models.BookingAdded, User: b.User, Name: b.Name, Env: b.Env, Booking: &c, Time: time.Now()})
+	})
+}
+
+// UpdateBooking replaces the attributes of the booking with the same ID as b
+func (s *SQL) UpdateBooking(b *models.Booking) error {
+	return s.tx(func(tx *sql.Tx) error {
+		data, e := encodeData(b)
+		if e != nil {
+			return e
+		}
+		res, e := tx.Exec(s.rebind(`UPDATE bookings SET data = ? WHERE id = ?`), data, b.ID)
+		if e != nil {
+			return e
+		}
+		n, e := res.RowsAffected()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			return err.BookingDoesNotExist
+		}
+		c := *b
+		return s.record(tx, &models.Event{Type: models.BookingUpdated, User: b.User, Name: b.Name, Env: b.Env, Booking: &c, Time: time.Now()})
+	})
+}
+
+func (s *SQL) RemoveBooking(id string) error {
+	return s.tx(func(tx *sql.Tx) error {
+		res, e := tx.Exec(s.rebind(`DELETE FROM bookings WHERE id = ?`), id)
+		if e != nil {
+			return e
+		}
+		n, e := res.RowsAffected()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			return err.BookingDoesNotExist
+		}
+		return s.record(tx, &models.Event{Type: models.BookingRemoved, Booking: &models.Booking{ID: id}, Time: time.Now()})
+	})
+}
+
+// GetBookings returns all bookings, ordered by start
+func (s *SQL) GetBookings() []*models.Booking {
+	ret := []*models.Booking{}
+
+	rows, e := s.db.Query(`SELECT data FROM bookings`)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := &models.Booking{}
+		data := ""
+		e := rows.Scan(&data)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		e = decodeData(data, b)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		ret = append(ret, b)
+	}
+	sortBookings(ret)
+	return ret
+}
+
+func (s *SQL) GetEvents(since time.Time) []*models.Event {
+	ret := []*models.Event{}
+
+	rows, e := s.db.Query(s.rebind(`SELECT op, env, name, user_id, user_name, at, data FROM history WHERE at >= ? ORDER BY id`), since)
+	if e != nil {
+		log.Errorf("%+v", e)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ev := &models.Event{}
+		uid, uname, data := "", "", ""
+		e := rows.Scan(&ev.Type, &ev.Env, &ev.Name, &uid, &uname, &ev.Time, &data)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		e = decodeData(data, ev)
+		if e != nil {
+			log.Errorf("%+v", e)
+			continue
+		}
+		if uid != "" {
+			ev.User = &models.User{
+				ID:   uid,
+				Name: uname,
+			}
+		}
+		ret = append(ret, ev)
+	}
+	return ret
+}
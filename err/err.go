@@ -4,9 +4,16 @@ import "errors"
 
 var (
 	AlreadyInQueue        = errors.New("ALREADY_IN_QUEUE")
+	BookingDoesNotExist   = errors.New("BOOKING_DOES_NOT_EXIST")
 	EnvDoesNotExist       = errors.New("ENV_DOES_NOT_EXIST")
+	InvalidDuration       = errors.New("INVALID_DURATION")
+	InvalidPriority       = errors.New("INVALID_PRIORITY")
 	InvalidResourceFormat = errors.New("INVALID_RESOURCE_FORMAT")
+	InvalidTime           = errors.New("INVALID_TIME")
 	NoResourceProvided    = errors.New("NO_RESOURCE_PROVIDED")
+	NotHolder             = errors.New("NOT_HOLDER")
 	NotInQueue            = errors.New("NOT_IN_QUEUE")
+	PoolDoesNotExist      = errors.New("POOL_DOES_NOT_EXIST")
 	ResourceDoesNotExist  = errors.New("RESOURCE_DOES_NOT_EXIST")
+	UnexpectedWords       = errors.New("UNEXPECTED_WORDS")
 )
@@ -3,6 +3,11 @@ module github.com/ameliagapin/reservebot
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.11.4
+	github.com/go-redis/redis/v7 v7.4.0
+	github.com/lib/pq v1.10.0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/slack-go/slack v0.6.4
+	go.etcd.io/bbolt v1.3.5
+	modernc.org/sqlite v1.10.8
 )
@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/ameliagapin/reservebot/data"
 	e "github.com/ameliagapin/reservebot/err"
 	"github.com/ameliagapin/reservebot/models"
 	"github.com/ameliagapin/reservebot/util"
 	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
 )
 
 const TICK = "`"
@@ -19,6 +20,7 @@ var (
 		"hello":          *regexp.MustCompile(`hello.+`),
 		"create":         *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\screate\s(.+)`),
 		"reserve":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sreserve\s(.+)`),
+		"reserve_all":    *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sreserve!\s(.+)`),
 		"release":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\srelease\s(.+)`),
 		"clear":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sclear\s(.+)`),
 		"kick_empty":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\skick$`),
@@ -32,9 +34,34 @@ var (
 		"nuke":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\snuke$`),
 		"prune":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sprune$`),
 		"help":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\shelp$`),
+		"limit":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\slimit\s(\S+)\s(\S+)$`),
+		"extend":         *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sextend\s(\S+)(?:\s(\S+))?$`),
+		"extensions":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sextensions\s(\S+)\s(\S+)$`),
+		"keep":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\skeep\s(.+)`),
+		"note":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\snote\s(\S+)(?:\s(.+))?$`),
+		"describe":       *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sdescribe\s(\S+)\s(.+)`),
+		"tag":            *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\stag\s(\S+)\s(.+)`),
+		"deadlocks":      *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sdeadlocks$`),
+		"capacity":       *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\scapacity\s(\S+)\s(\S+)$`),
+		"book":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sbook\s(\S+)\sfrom\s(.+?)\sto\s(.+)$`),
+		"calendar":       *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\scalendar\s(\S+)$`),
+		"unbook":         *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sunbook\s(\S+)$`),
+		"handoff":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\shandoff\s(\S+)\sto\s\<\@([a-zA-Z0-9]+)\>$`),
+		"move":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\smove\s\<\@([a-zA-Z0-9]+)\>\sto\s(\S+)\sin\s(\S+)$`),
+		"swap":           *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sswap\s\<\@([a-zA-Z0-9]+)\>\s\<\@([a-zA-Z0-9]+)\>\sin\s(\S+)$`),
+		"book_every":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sbook\s(\S+)\severy\s(\S+)\sfrom\s(\S+)\sto\s(\S+)$`),
+		"all_bookings":   *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sbookings$`),
+		"my_bookings":    *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\smy\sbookings$`),
+		"pause":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\spause\s(\S+)$`),
+		"resume":         *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sresume\s(\S+)$`),
+		"watch":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\swatch\s(\S+)$`),
+		"unwatch":        *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sunwatch\s(\S+)$`),
+		"my_watches":     *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\smy\swatches$`),
+		"stats":          *regexp.MustCompile(`(?m)^\<\@[A-Z0-9]+\>\sstats(?:\s(.+))?$`),
 
 		"create_dm":         *regexp.MustCompile(`(?m)^create\s(.+)`),
 		"reserve_dm":        *regexp.MustCompile(`(?m)^reserve\s(.+)`),
+		"reserve_all_dm":    *regexp.MustCompile(`(?m)^reserve!\s(.+)`),
 		"release_dm":        *regexp.MustCompile(`(?m)^release\s(.+)`),
 		"clear_dm":          *regexp.MustCompile(`(?m)^clear\s(.+)`),
 		"kick_dm":           *regexp.MustCompile(`(?m)^kick\s\<\@([a-zA-Z0-9]+)\>`),
@@ -46,49 +73,186 @@ var (
 		"nuke_dm":           *regexp.MustCompile(`(?m)^nuke$`),
 		"prune_dm":          *regexp.MustCompile(`(?m)^prune$`),
 		"help_dm":           *regexp.MustCompile(`(?m)^help$`),
+		"limit_dm":          *regexp.MustCompile(`(?m)^limit\s(\S+)\s(\S+)$`),
+		"extend_dm":         *regexp.MustCompile(`(?m)^extend\s(\S+)(?:\s(\S+))?$`),
+		"extensions_dm":     *regexp.MustCompile(`(?m)^extensions\s(\S+)\s(\S+)$`),
+		"keep_dm":           *regexp.MustCompile(`(?m)^keep\s(.+)`),
+		"note_dm":           *regexp.MustCompile(`(?m)^note\s(\S+)(?:\s(.+))?$`),
+		"describe_dm":       *regexp.MustCompile(`(?m)^describe\s(\S+)\s(.+)`),
+		"tag_dm":            *regexp.MustCompile(`(?m)^tag\s(\S+)\s(.+)`),
+		"deadlocks_dm":      *regexp.MustCompile(`(?m)^deadlocks$`),
+		"capacity_dm":       *regexp.MustCompile(`(?m)^capacity\s(\S+)\s(\S+)$`),
+		"book_dm":           *regexp.MustCompile(`(?m)^book\s(\S+)\sfrom\s(.+?)\sto\s(.+)$`),
+		"calendar_dm":       *regexp.MustCompile(`(?m)^calendar\s(\S+)$`),
+		"unbook_dm":         *regexp.MustCompile(`(?m)^unbook\s(\S+)$`),
+		"handoff_dm":        *regexp.MustCompile(`(?m)^handoff\s(\S+)\sto\s\<\@([a-zA-Z0-9]+)\>$`),
+		"move_dm":           *regexp.MustCompile(`(?m)^move\s\<\@([a-zA-Z0-9]+)\>\sto\s(\S+)\sin\s(\S+)$`),
+		"swap_dm":           *regexp.MustCompile(`(?m)^swap\s\<\@([a-zA-Z0-9]+)\>\s\<\@([a-zA-Z0-9]+)\>\sin\s(\S+)$`),
+		"book_every_dm":     *regexp.MustCompile(`(?m)^book\s(\S+)\severy\s(\S+)\sfrom\s(\S+)\sto\s(\S+)$`),
+		"all_bookings_dm":   *regexp.MustCompile(`(?m)^bookings$`),
+		"my_bookings_dm":    *regexp.MustCompile(`(?m)^my\sbookings$`),
+		"pause_dm":          *regexp.MustCompile(`(?m)^pause\s(\S+)$`),
+		"resume_dm":         *regexp.MustCompile(`(?m)^resume\s(\S+)$`),
+		"watch_dm":          *regexp.MustCompile(`(?m)^watch\s(\S+)$`),
+		"unwatch_dm":        *regexp.MustCompile(`(?m)^unwatch\s(\S+)$`),
+		"my_watches_dm":     *regexp.MustCompile(`(?m)^my\swatches$`),
+		"stats_dm":          *regexp.MustCompile(`(?m)^stats(?:\s(.+))?$`),
 	}
 )
 
 var (
-	msgAlreadyInAllQueues           = "Bruh, you are already in all specified queues"
-	msgCreatedResource              = "Resource is created."
-	msgIDontKnow                    = "I don't know what happened, but it wasn't good"
-	msgMustSpecifyResource          = "You must specify a resource"
-	msgMustSpecifyUser              = "You must specify a user to kick"
-	msgMustSpecifyValidResource     = "You must specify a valid resource"
-	msgMustUseReleaseForY           = "You cannot remove yourself from the queue for `%s` because you currently have it. Please use `release` instead."
-	msgMustUseRemoveForY            = "You cannot release `%s` because you do not currently have it. Please use `remove me from` instead."
-	msgNoReservations               = "Like Anthony Bourdain :rip:, there are _no reservations_. Lose yourself in the freedom of a world waiting on your next move."
-	msgPeriodItIsNowFree            = ". It is now free."
-	msgPeriodXHasItCurrently        = ". %s has it currently."
-	msgPeriodXStillHasIt            = ". %s still has it."
-	msgQueuesPruned                 = "I have removed all unreserved resources. Hope that's what you wanted. If not, it's too late now. Fool."
-	msgRemoveResourceNotFound       = "Resource cannot be removed, it was not found."
-	msgRemoveResourceReserved       = "Resource cannot be removed, it currently has active reservations."
-	msgRemoveResourceSuccess        = "Resource removed."
-	msgReservedButNotInQueue        = "%s reserved `%s`, but is currently not in the queue"
-	msgResourceDoesNotExistY        = "Resource `%s` does not exist"
-	msgResourceImproperlyFormatted  = "LOL u serious? Resources must be formatted as `<env>|<name>`. Example: `your_family|mom`"
-	msgUknownUser                   = "I'm sorry, I don't know who that is. Do _you_ know that is?"
-	msgXClearedY                    = "%s cleared `%s`"
-	msgXCurrentlyHas                = "%s currently has `%s`"
-	msgXHasBeenKickedFromNResources = "%s has been kicked from %d resource(s)"
-	msgXHasBeenRemovedFromY         = "%s has been kicked from `%s`. It's all yours. Get weird."
-	msgXHasBeenRemovedFromYZ        = "%s has been removed from the queue for `%s`%s"
-	msgXHasReleasedYItIsYours       = "%s has released `%s`. It's all yours. Get weird."
-	msgXHasReleasedYZ               = "%s has released `%s`%s"
-	msgXHasRemovedThemselvesFromYZ  = "%s has removed themselves from the queue for `%s`%s"
-	msgXItIsYours                   = "%s it's all yours. Get weird."
-	msgXKickedYouFromY              = "%s kicked you from `%s`"
-	msgXNukedQueue                  = "%s nuked the whole thing. Yikes."
-	msgYHasBeenCleared              = "`%s` has been cleared"
-	msgYouAreNInLineForY            = "You are %s in line for `%s`%s"
-	msgYouAreNotInLineForY          = "You are not in line for `%s`"
-	msgYouCurrentlyHave             = "You currently have `%s`"
-	msgYouHaveNoReservations        = "You have no reservations"
-	msgYouHaveReleasedY             = "You have released `%s`"
-	msgYouHaveRemovedXFromY         = "You have removed %s from `%s`"
-	msgYouHaveRemovedYourselfFromY  = "You have removed yourself from `%s`"
+	msgAllOfPoolXTakenQueuedForN          = "Everything in `%s` is taken. You are in line for all %d and will get whichever frees up first."
+	msgAlreadyInAllQueues                 = "Bruh, you are already in all specified queues"
+	msgAlreadyInLineForSomeOfThem         = "You are already in line for some of them. Leave those queues first to wait for all of them at once."
+	msgBookingMustEndAfterStart           = "A booking must end after it starts"
+	msgBookingMustStartInFuture           = "A booking must start in the future"
+	msgBookingXByYEveryZFromVToW          = "`%s` %s, every %s from %s to %s, next %s"
+	msgBookingXByYFromZToW                = "`%s` %s, %s to %s"
+	msgBookingXCancelled                  = "Booking `%s` has been cancelled"
+	msgBookingXDoesNotExist               = "Booking `%s` does not exist"
+	msgBookingXIsNotRecurring             = "Booking `%s` is not recurring, so it cannot be paused. Use `unbook` to cancel it."
+	msgBookingXIsNotYours                 = "You cannot cancel booking `%s` because it is not yours"
+	msgBookingXPaused                     = "Booking `%s` has been paused"
+	msgBookingXResumed                    = "Booking `%s` has been resumed"
+	msgBookingsForYX                      = "Bookings for `%s`:\n%s"
+	msgCapacityOfYIsN                     = "`%s` can now be held by %d user(s) at once"
+	msgColonNoteX                         = ": _%s_"
+	msgCommaExpiresInX                    = ", expires in %s"
+	msgContinued                          = "(continued)"
+	msgCreatedResource                    = "Resource is created."
+	msgExtensionsForYCappedAtN            = "Holds on `%s` can now be extended %d time(s)"
+	msgExtensionsForYUnlimited            = "Holds on `%s` can now be extended any number of times"
+	msgHeadsUpYIsBookedByXFromZToW        = "Heads up, `%s` is booked by %s from %s to %s. They will get it ahead of you then."
+	msgHoldLimitForYIsZ                   = "`%s` can now be held for %s"
+	msgHoldLimitForYRemoved               = "`%s` no longer has its own hold limit"
+	msgHomeAllResources                   = "*All resources*"
+	msgHomeEnvX                           = "*%s*"
+	msgHomeHeldYForX                      = "`%s`, held for %s%s"
+	msgHomeNInLineForY                    = "%s in line for `%s`.%s"
+	msgHomeTooManyResources               = "There are too many resources to show here. Use `status` to see all of them."
+	msgHomeYourReservations               = "*Your reservations*"
+	msgIDontKnow                          = "I don't know what happened, but it wasn't good"
+	msgInvalidDays                        = "Those are not days I understand. Try something like `weekday`, `weekend`, `day` or `mon,wed,fri`."
+	msgInvalidDuration                    = "That's not a duration I understand. Try something like `90m` or `2h`."
+	msgInvalidNumber                      = "That's not a number I understand. Try something like `3` or `off`."
+	msgInvalidPosition                    = "That's not a position I understand. Try something like `1`."
+	msgInvalidPriority                    = "That's not a priority I understand. Try `low`, `normal` or `high`."
+	msgInvalidTime                        = "That's not a time I understand. Try something like `14:00`, `tomorrow 14:00` or `2021-03-14 14:00`."
+	msgMustSpecifyResource                = "You must specify a resource"
+	msgMustSpecifyUser                    = "You must specify a user to kick"
+	msgMustSpecifyValidResource           = "You must specify a valid resource"
+	msgMustUseReleaseForY                 = "You cannot remove yourself from the queue for `%s` because you currently have it. Please use `release` instead."
+	msgMustUseRemoveForY                  = "You cannot release `%s` because you do not currently have it. Please use `remove me from` instead."
+	msgNWaitingForYForZStillUsingIt       = "%d people are waiting for `%s` and you have had it for %s. Are you still using it? Reply `release %s` if you're done, or `keep %[2]s` if you still need it."
+	msgNewlineBookedX                     = "\n>Booked: %s"
+	msgNoBookings                         = "There are no bookings"
+	msgNoBookingsForY                     = "There are no upcoming bookings for `%s`"
+	msgNoDeadlocks                        = "No deadlocks. Everyone is waiting on someone who is not waiting on them."
+	msgNoReservations                     = "Like Anthony Bourdain :rip:, there are _no reservations_. Lose yourself in the freedom of a world waiting on your next move."
+	msgNoResourcesInPoolX                 = "There are no resources in `%s`"
+	msgNoResourcesTaggedX                 = "No resources are tagged `%s`"
+	msgNoStats                            = "There are no stats yet"
+	msgNoStatsForY                        = "There are no stats for `%s`"
+	msgNoteForYCleared                    = "Your note for `%s` has been cleared"
+	msgNoteForYIsZ                        = "Your note for `%s` is now _%s_"
+	msgPeriodItCannotBeExtended           = ". It cannot be extended any further."
+	msgPeriodItIsNowFree                  = ". It is now free."
+	msgPeriodReplyExtendY                 = ". Reply `extend %s` to keep it longer, or add a duration, e.g. `extend %[1]s 1h`."
+	msgPeriodXHasItCurrently              = ". %s has it currently."
+	msgPeriodXStillHasIt                  = ". %s still has it."
+	msgPoolXDoesNotExist                  = "Pool `%s` does not exist"
+	msgQueuesPruned                       = "I have removed all unreserved resources. Hope that's what you wanted. If not, it's too late now. Fool."
+	msgRemoveResourceNotFound             = "Resource cannot be removed, it was not found."
+	msgRemoveResourceReserved             = "Resource cannot be removed, it currently has active reservations."
+	msgRemoveResourceSuccess              = "Resource removed."
+	msgReservedButNotInQueue              = "%s reserved `%s`, but is currently not in the queue"
+	msgResourceDoesNotExistY              = "Resource `%s` does not exist"
+	msgResourceImproperlyFormatted        = "LOL u serious? Resources must be formatted as `<env>|<name>`. Example: `your_family|mom`"
+	msgSpaceNow                           = " (now)"
+	msgSpacePaused                        = " (paused)"
+	msgSpacePriorityX                     = " _(%s priority)_"
+	msgSpaceSetAsideForAllOrNothing       = " _(set aside until the rest of their request is free)_"
+	msgSpaceYouShouldGetItInAboutXAroundY = " You should get it in about %s, around %s."
+	msgSpaceYouShouldGetItSoon            = " You should get it soon."
+	msgSpaceYourNoteX                     = " Your note: _%s_"
+	msgStatsForTheLastXY                  = "Stats for the last %s:\n%s"
+	msgStatsX                             = "`%s`: %d reservations, held %s (%d%%), idle %s, median wait %s, p95 wait %s, peak queue %d"
+	msgUknownUser                         = "I'm sorry, I don't know who that is. Do _you_ know that is?"
+	msgUnexpectedWordsX                   = "I don't know what to do with `%s`. Try something like `reserve qa|web for 2h priority low -- testing`."
+	msgXAlreadyHasY                       = "%s already has `%s`"
+	msgXAlreadyHasYFromPoolZ              = "%s already has `%s` from `%s`"
+	msgXAndYHaveSwappedPlacesForZ         = "%s and %s have swapped places in line for `%s`"
+	msgXClearedY                          = "%s cleared `%s`"
+	msgXCurrentlyHas                      = "%s currently has `%s`"
+	msgXHasBeenKickedFromNResources       = "%s has been kicked from %d resource(s)"
+	msgXHasBeenRemovedFromY               = "%s has been kicked from `%s`. It's all yours. Get weird."
+	msgXHasBeenRemovedFromYZ              = "%s has been removed from the queue for `%s`%s"
+	msgXHasHandedYToYouItIsYours          = "%s has handed `%s` over to you. It's all yours. Get weird."
+	msgXHasHandedYToZ                     = "%s has handed `%s` over to %s"
+	msgXHasReleasedYItIsYours             = "%s has released `%s`. It's all yours. Get weird."
+	msgXHasReleasedYZ                     = "%s has released `%s`%s"
+	msgXHasRemovedThemselvesFromYZ        = "%s has removed themselves from the queue for `%s`%s"
+	msgXIsAlreadyInPoolY                  = "%s is already in line for `%s`"
+	msgXIsNotInLineForY                   = "%s is not in line for `%s`"
+	msgXIsNowNInLineForY                  = "%s is now %s in line for `%s`"
+	msgXIsWaitingForAllOfY                = "%s (%s) is waiting for all of %s%s"
+	msgXItIsYours                         = "%s it's all yours. Get weird."
+	msgXKickedYouFromY                    = "%s kicked you from `%s`"
+	msgXMadeAReservationForYouZ           = "%s made a reservation for you. %s"
+	msgXNukedQueue                        = "%s nuked the whole thing. Yikes."
+	msgXRaisedCapacityOfYItIsYours        = "%s raised the capacity of `%s`. It's all yours. Get weird."
+	msgXReorderedYBackInLine              = "%s reordered the queue for `%s`, so you are back in line for it"
+	msgXReorderedYItIsYours               = "%s reordered the queue for `%s`. It's all yours. Get weird."
+	msgXWaitsForYHeldByZ                  = "%s is waiting for `%s` held by %s"
+	msgXsBookingOfYEndedItIsYours         = "%s's booking of `%s` ended. It's all yours. Get weird."
+	msgXsBookingOfYStartedBackInLine      = "%s's booking of `%s` has started, so you are back in line for it"
+	msgXsHoldOnYExpiredItIsYours          = "%s's hold on `%s` expired. It's all yours. Get weird."
+	msgYCannotBeExtendedAgain             = "Your hold on `%s` has been extended as many times as allowed"
+	msgYColonX                            = "`%s`: %s"
+	msgYDoesNotExpire                     = "Your hold on `%s` does not expire, so there is nothing to extend"
+	msgYHasBeenCleared                    = "`%s` has been cleared"
+	msgYHasBeenRemoved                    = "`%s` has been removed, so you are no longer watching it"
+	msgYHasBeenDescribed                  = "`%s` has been described"
+	msgYHasBeenTagged                     = "`%s` has been tagged"
+	msgYHasChangedHandsXHasItNow          = "`%s` has changed hands. %s has it now."
+	msgYIsAlreadyBookedX                  = "`%s` is already booked then:\n%s"
+	msgYIsNowFree                         = "`%s` is now free"
+	msgYouAlreadyHaveYFromPoolX           = "You already have `%s` from `%s`"
+	msgYouAreAlreadyInPoolX               = "You are already in line for `%s`"
+	msgYouAreAlreadyWatchingY             = "You are already watching `%s`"
+	msgYouAreInDeadlockX                  = "Heads up, you are in a deadlock: %s. Someone needs to release something or nobody gets anywhere."
+	msgYouAreKeepingY                     = "Got it, you're keeping `%s`. I'll check in again later."
+	msgYouAreNInLineForY                  = "You are %s in line for `%s`%s"
+	msgYouAreNoLongerWaitingForAllOfY     = "You are no longer waiting for all of %s"
+	msgYouAreNoLongerWatchingY            = "You are no longer watching `%s`"
+	msgYouAreNotInLineForY                = "You are not in line for `%s`"
+	msgYouAreNotWatchingAnything          = "You are not watching anything"
+	msgYouAreNotWatchingY                 = "You are not watching `%s`"
+	msgYouAreNowWatchingY                 = "You are now watching `%s`. I'll let you know when it frees up or changes hands."
+	msgYouAreWatchingX                    = "You are watching:\n%s"
+	msgYouCannotHandOffY                  = "You cannot hand off `%s` because you do not currently have it"
+	msgYouCannotKeepY                     = "You cannot keep `%s` because you do not currently have it"
+	msgYouCannotReserveForOthers          = "You are not allowed to reserve for someone else there"
+	msgYouCannotReserveWithXPriority      = "You are not allowed to reserve with %s priority"
+	msgYouCurrentlyHave                   = "You currently have `%s`"
+	msgYouCurrentlyHaveAllOfY             = "You currently have all of %s"
+	msgYouDoNotHaveY                      = "You cannot extend `%s` because you do not currently have it"
+	msgYouHaveBookedYZ                    = "You have booked `%s`: %s"
+	msgYouHaveExtendedYZ                  = "You have extended your hold on `%s`%s"
+	msgYouHaveHandedYToX                  = "You have handed `%s` over to %s"
+	msgYouHaveNoReservations              = "You have no reservations"
+	msgYouHaveReleasedY                   = "You have released `%s`"
+	msgYouHaveRemovedXFromY               = "You have removed %s from `%s`"
+	msgYouHaveRemovedYourselfFromY        = "You have removed yourself from `%s`"
+	msgYouHaveReservedYForX               = "You have reserved `%s` for %s"
+	msgYouHaveYFromPoolX                  = "You have `%s` from `%s`"
+	msgYouNowHaveAllOfY                   = "All of %s were free, so they are now yours. Get weird."
+	msgYouWillGetAllOfYWhenFree           = "Not all of %s are free. You will get them all at once as soon as they are."
+	msgYourBookingOfYEnded                = "Your booking of `%s` has ended so it has been released"
+	msgYourBookingOfYStartedUntilZ        = "Your booking of `%s` has started. It's all yours until %s. Get weird."
+	msgYourHoldOnYExpired                 = "Your hold on `%s` expired so it has been released"
+	msgYourHoldOnYExpiresInZ              = "Your hold on `%s` expires in %s"
+	msgYourReservationCausedDeadlockX     = "Heads up, that caused a deadlock: %s. Someone needs to release something or nobody gets anywhere."
 )
 
 func (h *Handler) getAction(text string) string {
@@ -102,7 +266,6 @@ func (h *Handler) getAction(text string) string {
 
 func (h *Handler) create(ea *EventAction) error {
 	ev := ea.Event
-
 	matches := h.getMatches(ea.Action, ev.Text)
 	resources, err := h.getResourcesFromCommaList(matches[0])
 	if err != nil {
@@ -116,7 +279,7 @@ func (h *Handler) create(ea *EventAction) error {
 		if err != nil {
 			// if the user is already in the queue, we're going to skip returning an error
 			if err != e.AlreadyInQueue {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, err.Error())
 				continue
 			}
 		} else {
@@ -132,24 +295,78 @@ func (h *Handler) reserve(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
 	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
+	list, mods, err := parseModifiers(matches[0])
+	switch err {
+	case nil:
+	case e.InvalidPriority:
+		h.errorReply(ea, msgInvalidPriority)
+		return err
+	case e.InvalidDuration:
+		h.errorReply(ea, msgInvalidDuration)
+		return err
+	case e.UnexpectedWords:
+		h.errorReply(ea, fmt.Sprintf(msgUnexpectedWordsX, list))
+		return nil
+	default:
+		h.errorReply(ea, err.Error())
+		return err
+	}
+	note, behalf, priority, hold := mods.Note, mods.Behalf, mods.Priority, mods.Hold
+	// by is the user reserving on behalf of someone else, who then takes the place of u
+	var by *models.User
+	if behalf != "" && behalf != u.ID {
+		by = u
+		u, err = h.getUser(behalf)
+		if err != nil {
+			log.Errorf("%+v", err)
+			h.reply(ea, msgUknownUser, true)
+			return err
+		}
+	}
+	// Whoever makes the request picks the priority
+	requester := u
+	if by != nil {
+		requester = by
+	}
+	if !h.canPrioritize(requester, priority) {
+		h.reply(ea, fmt.Sprintf(msgYouCannotReserveWithXPriority, priority), false)
+		return nil
+	}
+	if name, pattern, ok, err := h.parsePool(list); ok {
+		if err != nil {
+			h.errorReply(ea, fmt.Sprintf(msgPoolXDoesNotExist, name))
+			return err
+		}
+		if by != nil && !h.canReserveFor(by, h.getPoolMembers(pattern)) {
+			h.reply(ea, msgYouCannotReserveForOthers, false)
+			return nil
+		}
+		return h.reservePool(ea, u, name, pattern, hold, note, priority, by)
+	}
+	resources, err := h.getResourcesFromCommaList(list)
 	if err != nil {
 		h.handleGetResourceError(ea, err)
 		return err
 	}
+	if by != nil && !h.canReserveFor(by, resources) {
+		h.reply(ea, msgYouCannotReserveForOthers, false)
+		return nil
+	}
 
 	success := []*models.Resource{}
 	for _, res := range resources {
-		err := h.data.Reserve(u, res.Name, res.Env)
+		err := h.watchQueue(res.Name, res.Env, func() error {
+			return h.data.Reserve(u, res.Name, res.Env)
+		})
 		if err != nil {
 			// if the user is already in the queue, we're going to skip returning an error
 			if err != e.AlreadyInQueue {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, err.Error())
 				continue
 			}
 		}
@@ -160,27 +377,79 @@ func (h *Handler) reserve(ea *EventAction) error {
 		return h.reply(ea, msgAlreadyInAllQueues, true)
 	}
 
+	if hold > 0 || note != "" || priority != models.PriorityNormal || by != nil {
+		for _, res := range success {
+			err := h.data.UpdateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+				if hold > 0 {
+					r.Hold = hold
+				}
+				if note != "" {
+					r.Note = note
+				}
+				if priority != models.PriorityNormal {
+					r.Priority = priority
+				}
+				if by != nil {
+					r.CreatedBy = by
+				}
+				return nil
+			})
+			if err != nil && err != e.NotInQueue {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+
+	// Everyone is queued behind those waiting with the same or a higher priority
+	for _, res := range success {
+		err := h.prioritize(u, res.Name, res.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+
+	averages := h.getAverageHolds()
 	for _, res := range success {
 		pos, err := h.data.GetPosition(u, res.Name, res.Env)
 		if err != nil {
 			// This case really should never happen here, as we are only looping through our success cases
 			log.Errorf("%+v", err)
-			h.errorReply(ev.Channel, msgIDontKnow)
+			h.errorReply(ea, msgIDontKnow)
 			return err
 		}
 		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			log.Errorf("%+v", err)
 			continue
 		}
-		switch pos {
-		case 0:
+		switch {
+		case pos == 0:
 			log.Errorf(msgReservedButNotInQueue, h.getUserDisplay(u, false), res)
-		case 1:
+		case by != nil:
+			// The reservation was made for someone else, so they get the news and the requester gets a receipt
+			msg := fmt.Sprintf(msgYouCurrentlyHave, res)
+			if !h.isHolder(res.Name, res.Env, pos) {
+				c := ""
+				if cu != nil {
+					c = fmt.Sprintf(msgPeriodXHasItCurrently, h.getUserDisplayWithDuration(cu, false))
+				}
+				c += h.getEstimateText(u, res, averages)
+				msg = fmt.Sprintf(msgYouAreNInLineForY, util.Ordinalize(pos), res, c)
+			}
+			err = h.sendDM(u, fmt.Sprintf(msgXMadeAReservationForYouZ, h.getUserDisplay(by, false), msg))
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			err = h.reply(ea, fmt.Sprintf(msgYouHaveReservedYForX, res, h.getUserDisplay(u, false)), true)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			continue
+		case h.isHolder(res.Name, res.Env, pos):
 			msg := fmt.Sprintf(msgYouCurrentlyHave, res)
 			if ev.ChannelType != "im" {
-				msg = fmt.Sprintf(msgXCurrentlyHas, h.getUserDisplayWithDuration(cu, true), res)
+				msg = fmt.Sprintf(msgXCurrentlyHas, h.getUserDisplayWithDuration(h.data.GetReservation(u, res.Name, res.Env), true), res)
 			}
 			err = h.reply(ea, msg, false)
 			if err != nil {
@@ -191,14 +460,21 @@ func (h *Handler) reserve(ea *EventAction) error {
 			if cu != nil {
 				c = fmt.Sprintf(msgPeriodXHasItCurrently, h.getUserDisplayWithDuration(cu, false))
 			}
+			c += h.getEstimateText(u, res, averages)
 			msg := fmt.Sprintf(msgYouAreNInLineForY, util.Ordinalize(pos), res, c)
 			err = h.reply(ea, msg, true)
 			if err != nil {
 				log.Errorf("%+v", err)
 			}
 		}
+
+		if msg := h.getUpcomingBookingText(u, res); msg != "" {
+			h.reply(ea, msg, true)
+		}
 	}
 
+	h.warnDeadlocks(ea, requester, u)
+
 	return nil
 }
 
@@ -207,7 +483,7 @@ func (h *Handler) release(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -219,35 +495,40 @@ func (h *Handler) release(ea *EventAction) error {
 	}
 
 	success := []*models.Resource{}
+	// next holds the reservation of whoever is promoted to a holder by each release
+	next := map[string]*models.Reservation{}
 	for _, res := range resources {
 		r := h.data.GetResource(res.Name, res.Env, false)
 		if r == nil {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 			continue
 		}
 
 		pos, err := h.data.GetPosition(u, res.Name, res.Env)
 		if err != nil {
 			if err == e.NotInQueue {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+				h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
 
-		switch pos {
-		case 0:
-			h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+		switch {
+		case pos == 0:
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 			continue
-		case 1:
-			err := h.data.Remove(u, res.Name, res.Env)
+		case h.isHolder(res.Name, res.Env, pos):
+			next[res.Key()] = h.getNextInLine(res.Name, res.Env)
+			err := h.watchQueue(res.Name, res.Env, func() error {
+				return h.data.Remove(u, res.Name, res.Env)
+			})
 			if err != nil {
 				if err == e.NotInQueue {
 					h.reply(ea, fmt.Sprintf(msgMustUseRemoveForY, res), true)
 					continue
 				}
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, err.Error())
 				continue
 			}
 			success = append(success, res)
@@ -258,15 +539,7 @@ func (h *Handler) release(ea *EventAction) error {
 	}
 
 	for _, res := range success {
-		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
-		if err != nil {
-			if err == e.ResourceDoesNotExist {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
-				continue
-			}
-			h.errorReply(ev.Channel, err.Error())
-			continue
-		}
+		cu := next[res.Key()]
 
 		if ea.Event.ChannelType == "im" {
 			// Confirm for user
@@ -291,56 +564,148 @@ func (h *Handler) release(ea *EventAction) error {
 	return nil
 }
 
-func (h *Handler) removeme(ea *EventAction) error {
+// handoff hands a resource the user holds over to another user without releasing it to the next in line
+func (h *Handler) handoff(ea *EventAction) error {
 	ev := ea.Event
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
 	matches := h.getMatches(ea.Action, ev.Text)
-	resources, err := h.getResourcesFromCommaList(matches[0])
-	if err != nil {
+	res, err := h.parseResource(strings.Trim(matches[0], " `"))
+	if err != nil || res == nil {
 		h.handleGetResourceError(ea, err)
 		return err
 	}
+	to, err := h.getUser(matches[1])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+
+	if h.getHeldReservation(to, res.Name, res.Env) != nil {
+		h.errorReply(ea, fmt.Sprintf(msgXAlreadyHasY, h.getUserDisplay(to, false), res))
+		return nil
+	}
+	// A place set aside for a pending composite request isn't the user's to give away
+	if h.getHeldReservation(u, res.Name, res.Env) == nil {
+		h.errorReply(ea, fmt.Sprintf(msgYouCannotHandOffY, res))
+		return nil
+	}
+
+	err = h.watchQueue(res.Name, res.Env, func() error {
+		return h.data.Handoff(u, to, res.Name, res.Env)
+	})
+	if err != nil {
+		switch err {
+		case e.ResourceDoesNotExist:
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+		case e.NotInQueue, e.NotHolder:
+			h.errorReply(ea, fmt.Sprintf(msgYouCannotHandOffY, res))
+		default:
+			h.errorReply(ea, err.Error())
+		}
+		return nil
+	}
+
+	if ea.Event.ChannelType == "im" {
+		h.reply(ea, fmt.Sprintf(msgYouHaveHandedYToX, res, h.getUserDisplay(to, false)), false)
+		h.announce(ea, to, fmt.Sprintf(msgXHasHandedYToYouItIsYours, h.getUserDisplay(u, false), res))
+	} else {
+		h.reply(ea, fmt.Sprintf(msgXHasHandedYToZ, h.getUserDisplay(u, false), res, h.getUserDisplay(to, true)), false)
+	}
+
+	// Let everyone waiting know who has it now
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return nil
+	}
+	h.warnDeadlocks(ea, u, to)
+	for _, next := range q.Waiting() {
+		err := h.sendDM(next.User, fmt.Sprintf(msgXHasHandedYToZ, h.getUserDisplay(u, false), res, h.getUserDisplay(to, false)))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) removeme(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	var resources []*models.Resource
+	if name, pattern, ok, err := h.parsePool(matches[0]); ok {
+		if err != nil {
+			h.errorReply(ea, fmt.Sprintf(msgPoolXDoesNotExist, name))
+			return err
+		}
+		resources = h.getPoolReservations(u, name, pattern)
+		if len(resources) == 0 {
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, name))
+			return nil
+		}
+	} else {
+		resources, err = h.getResourcesFromCommaList(matches[0])
+		if err != nil {
+			h.handleGetResourceError(ea, err)
+			return err
+		}
+	}
 
 	for _, res := range resources {
 		r := h.data.GetResource(res.Name, res.Env, false)
 		if r == nil {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+
+		if removed := h.removeComposites(u, res); len(removed) > 0 {
+			for _, c := range removed {
+				h.reply(ea, fmt.Sprintf(msgYouAreNoLongerWaitingForAllOfY, c), true)
+			}
 			continue
 		}
 
 		pos, err := h.data.GetPosition(u, res.Name, res.Env)
 		if err != nil {
 			if err == e.NotInQueue {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+				h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
 
-		switch pos {
-		case 0:
-			h.errorReply(ev.Channel, fmt.Sprintf(msgYouAreNotInLineForY, res))
+		switch {
+		case pos == 0:
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
 			continue
-		case 1:
+		case h.isHolder(res.Name, res.Env, pos):
 			h.reply(ea, fmt.Sprintf(msgMustUseReleaseForY, res), true)
 			continue
 		default:
 			err = h.data.Remove(u, res.Name, res.Env)
 			if err != nil {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, err.Error())
 				continue
 			}
 
 			cu, err := h.data.GetReservationForResource(res.Name, res.Env)
 			if err != nil {
-				h.errorReply(ev.Channel, err.Error())
+				h.errorReply(ea, err.Error())
 				continue
 			}
 
@@ -367,7 +732,7 @@ func (h *Handler) allStatus(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -383,7 +748,17 @@ func (h *Handler) allStatus(ea *EventAction) error {
 		return h.reply(ea, msgNoReservations, false)
 	}
 
-	resp := ""
+	var only *models.User
+	var averages map[string]time.Duration
+	if userOnly {
+		only = u
+		averages = h.getAverageHolds()
+	}
+	resp := h.getCompositesText(only)
+	blocks := []slack.Block{}
+	if resp != "" {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, resp, false, false), nil, nil))
+	}
 	for _, res := range all {
 		if userOnly {
 			// Discarding the err here. Func returns 0 when there's an err so we'll use that as an indication
@@ -396,11 +771,22 @@ func (h *Handler) allStatus(ea *EventAction) error {
 		msg, err := h.getCurrentResText(res, false)
 		if err != nil {
 			log.Errorf("%+v", err)
-			h.errorReply(ev.Channel, "")
+			h.errorReply(ea, "")
 			continue
 		}
+		if userOnly {
+			// The holder's note is already part of the resource's text
+			r := h.data.GetReservation(u, res.Name, res.Env)
+			if r != nil && h.getHeldReservation(u, res.Name, res.Env) == nil {
+				if r.Note != "" {
+					msg += fmt.Sprintf(msgSpaceYourNoteX, r.Note)
+				}
+				msg += h.getEstimateText(u, res, averages)
+			}
+		}
 
 		resp += msg + "\n"
+		blocks = append(blocks, h.getResourceBlocks(getButtonUser(ea, u), res, msg)...)
 	}
 
 	if resp == "" {
@@ -411,39 +797,50 @@ func (h *Handler) allStatus(ea *EventAction) error {
 		}
 	}
 	// Only address the user if they asked for *their* status
-	h.reply(ea, resp, userOnly)
+	h.replyWithBlocks(ea, resp, userOnly, blocks)
 
 	return nil
 }
 
 func (h *Handler) singleStatus(ea *EventAction) error {
 	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
 	r := h.getMatches(ea.Action, ev.Text)
 
 	if len(r) == 0 {
-		h.errorReply(ev.Channel, msgMustSpecifyResource)
+		h.errorReply(ea, msgMustSpecifyResource)
 		return nil
 	}
 
+	if strings.HasPrefix(r[0], "tag:") {
+		return h.tagStatus(ea, strings.TrimPrefix(r[0], "tag:"))
+	}
+
 	res, err := h.parseResource(r[0])
 	if err != nil {
 		// Probably don't need to insult the user for resource formatting here
-		h.errorReply(ev.Channel, msgMustSpecifyValidResource)
+		h.errorReply(ea, msgMustSpecifyValidResource)
 		return nil
 	}
 
 	msg, err := h.getCurrentResText(res, false)
 	if err != nil {
 		if err == e.ResourceDoesNotExist {
-			h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 			return nil
 		}
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
-	h.reply(ea, msg, false)
+	h.replyWithBlocks(ea, msg, false, h.getResourceBlocks(getButtonUser(ea, u), res, msg))
 
 	return nil
 }
@@ -453,7 +850,7 @@ func (h *Handler) clear(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -468,16 +865,18 @@ func (h *Handler) clear(ea *EventAction) error {
 		q, err := h.data.GetQueueForResource(res.Name, res.Env)
 		if err != nil {
 			if err == e.ResourceDoesNotExist {
-				h.errorReply(ev.Channel, fmt.Sprintf(msgResourceDoesNotExistY, res))
+				h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
 
-		err = h.data.ClearQueueForResource(res.Name, res.Env)
+		err = h.watchQueue(res.Name, res.Env, func() error {
+			return h.data.ClearQueueForResource(res.Name, res.Env)
+		})
 		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
 
@@ -502,7 +901,7 @@ func (h *Handler) kick(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -531,30 +930,27 @@ func (h *Handler) kick(ea *EventAction) error {
 				// this error does not need to be reported to the user
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
-		if pos != 1 {
+		if !h.isHolder(res.Name, res.Env, pos) {
 			continue
 		}
 
-		err = h.data.Remove(uToKick, res.Name, res.Env)
+		cu := h.getNextInLine(res.Name, res.Env)
+		err = h.watchQueue(res.Name, res.Env, func() error {
+			return h.data.Kick(uToKick, res.Name, res.Env)
+		})
 		if err != nil {
 			if err == e.NotInQueue {
 				// this error does not need to be reported to the user
 				continue
 			}
-			h.errorReply(ev.Channel, err.Error())
+			h.errorReply(ea, err.Error())
 			continue
 		}
 		count++
 
-		cu, err := h.data.GetReservationForResource(res.Name, res.Env)
-		if err != nil {
-			h.errorReply(ev.Channel, err.Error())
-			continue
-		}
-
 		if ev.ChannelType == "im" {
 			// We will need to confirm to the user
 			h.reply(ea, fmt.Sprintf(msgYouHaveRemovedXFromY, h.getUserDisplay(uToKick, true), res), false)
@@ -592,7 +988,7 @@ func (h *Handler) nuke(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -601,7 +997,12 @@ func (h *Handler) nuke(ea *EventAction) error {
 		return nil
 	}
 
-	h.data = data.NewMemory()
+	err = h.data.Nuke()
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
 
 	msg := fmt.Sprintf(msgXNukedQueue, h.getUserDisplay(u, true))
 	h.reply(ea, msg, false)
@@ -614,7 +1015,7 @@ func (h *Handler) prune(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -623,24 +1024,12 @@ func (h *Handler) prune(ea *EventAction) error {
 		return nil
 	}
 
-	resources := h.data.GetResources()
-	for _, res := range resources {
-		q, err := h.data.GetQueueForResource(res.Name, res.Env)
-		if err != nil {
-			// this shouldn't happen, but there's nothing to alert the user to
-			log.Errorf("%+v", err)
-			continue
-		}
-
-		if q.HasReservations() {
-			continue
-		}
-
-		err = h.data.RemoveResource(res.Name, res.Env)
-		if err != nil {
-			log.Errorf("%+v", err)
-			continue
-		}
+	// Pruning with no expiration removes every resource that does not have reservations
+	err = h.Prune(0)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
 	}
 
 	h.reply(ea, msgQueuesPruned, false)
@@ -653,7 +1042,7 @@ func (h *Handler) removeresource(ea *EventAction) error {
 	_, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -690,7 +1079,9 @@ func (h *Handler) removeresource(ea *EventAction) error {
 			continue
 		}
 
-		err = h.data.RemoveResource(res.Name, res.Env)
+		err = h.watchRemoval(func() error {
+			return h.data.RemoveResource(res.Name, res.Env)
+		})
 		if err != nil {
 			log.Errorf("%+v", err)
 			continue
@@ -711,7 +1102,7 @@ func (h *Handler) help(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -727,11 +1118,31 @@ func (h *Handler) help(ea *EventAction) error {
 	helpText += "When invoking within a channel, you must @-mention me by adding " + TICK + "@reservebot" + TICK + "to the _beginning_ of your command.\n\n"
 
 	helpText += TICK + "create <resource>" + TICK + "This will create a free resource.\n\n"
-	helpText += TICK + "reserve <resource>" + TICK + " This will reserve a given resource for the user. If the resource is currently reserved, the user will be placed into the queue. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources.\n\n"
+	helpText += TICK + "reserve <resource>" + TICK + " This will reserve a given resource for the user. If the resource is currently reserved, the user will be placed into the queue. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources. Add " + TICK + "for <duration>" + TICK + ", e.g. " + TICK + "for 2h" + TICK + ", to release it automatically after that long.\n\n"
+	helpText += TICK + "reserve! <resource>,<resource>" + TICK + " This will reserve all of the given resources at once if they are all free. Otherwise, you will get them all at once as soon as they are, and hold none of them until then.\n\n"
+	helpText += TICK + "reserve any <pattern>" + TICK + " This will reserve the first free resource matching a pattern, e.g. " + TICK + "reserve any qa|web*" + TICK + ". If they are all taken, you will be placed into the queue for all of them and get whichever frees up first. " + TICK + "reserve pool:<pool>" + TICK + " does the same for a configured pool.\n\n"
+	helpText += TICK + "reserve <resource> priority <low|normal|high>" + TICK + " This will reserve a resource like " + TICK + "reserve" + TICK + ", but place you in line ahead of everyone waiting with a lower priority. Only some users can use " + TICK + "high" + TICK + ".\n\n"
+	helpText += TICK + "reserve <resource> for <@user>" + TICK + " This will reserve a resource on behalf of the mentioned user, who is notified via DM. Only admins and the owners of the resource's env can do this.\n\n"
+	helpText += TICK + "reserve <resource> -- <note>" + TICK + " This will reserve a resource with a note saying why you need it, e.g. " + TICK + "reserve qa|web -- testing PR #123" + TICK + ". The note is shown in the status.\n\n"
+	helpText += TICK + "note <resource> [note]" + TICK + " This will change the note on your reservation of a resource. Without a note, the note is cleared.\n\n"
 	helpText += TICK + "release <resource>" + TICK + " This will release a given resource. This command must be executed by the person who holds the resource. Upon release, the next person waiting in line will be notified that they now have the resource. The resource should be an alphanumeric string with no spaces. A comma-separted list can be used to reserve multiple resources.\n\n"
+	helpText += TICK + "handoff <resource> to <@user>" + TICK + " This will hand a resource you hold over to the mentioned user, even if they are not in line for it. The people waiting in line keep their places and are notified.\n\n"
+	helpText += TICK + "extend <resource> [duration]" + TICK + " This will extend your hold on a resource that has a hold limit. Without a duration, the hold is extended by its limit.\n\n"
+	helpText += TICK + "keep <resource>" + TICK + " This will tell me you are still using a resource that others are waiting for, so I stop asking for a while.\n\n"
 	helpText += TICK + "status" + TICK + " This will provide a status of all active resources.\n\n"
 	helpText += TICK + "my status" + TICK + " This will provide a status of all active and queue reservations for the user.\n\n"
 	helpText += TICK + "status <resource>" + TICK + " This will provide a status of a given resource.\n\n"
+	helpText += TICK + "status tag:<tag>" + TICK + " This will provide a status of all resources with a given tag.\n\n"
+	helpText += TICK + "describe <resource> <description>" + TICK + " This will set the description of a resource. Include " + TICK + "team:<team>" + TICK + " to set its owning team and links to replace its links, or use " + TICK + "clear" + TICK + " to remove them all.\n\n"
+	helpText += TICK + "tag <resource> +<tag> -<tag>" + TICK + " This will add tags to and remove tags from a resource.\n\n"
+	helpText += TICK + "watch <resource>" + TICK + " This will tell you via DM when a resource becomes free or changes hands, without putting you in line for it. Use " + TICK + "unwatch <resource>" + TICK + " to stop and " + TICK + "my watches" + TICK + " to list what you are watching.\n\n"
+	helpText += TICK + "book <resource> from <time> to <time>" + TICK + " This will book a resource for a window of time, e.g. " + TICK + "book qa|perf from tomorrow 14:00 to 17:00" + TICK + ". When the window starts, you will get the resource ahead of anyone waiting for it, and it is released when the window ends.\n\n"
+	helpText += TICK + "book <resource> every <days> from <time> to <time>" + TICK + " This will book a resource for the same window on every given day, e.g. " + TICK + "book ci|perf every weekday from 01:00 to 04:00" + TICK + ". Days can be " + TICK + "day" + TICK + ", " + TICK + "weekday" + TICK + ", " + TICK + "weekend" + TICK + " or a list such as " + TICK + "mon,wed,fri" + TICK + ".\n\n"
+	helpText += TICK + "pause <id>" + TICK + " This will pause one of your recurring bookings so that its occurrences are skipped until you " + TICK + "resume <id>" + TICK + " it.\n\n"
+	helpText += TICK + "calendar <resource>" + TICK + " This will list the current and upcoming bookings of a resource.\n\n"
+	helpText += TICK + "bookings" + TICK + " This will list all bookings. Use " + TICK + "my bookings" + TICK + " to list only yours.\n\n"
+	helpText += TICK + "unbook <id>" + TICK + " This will cancel a booking. Only the person who made it, or an admin, can cancel it.\n\n"
+	helpText += TICK + "stats [resource|env] [duration] [csv]" + TICK + " This will show how much resources were used over the given time, 7 days by default: how many reservations there were, how long they were held and idle, how long people waited and the longest the queue got. With " + TICK + "csv" + TICK + ", the stats are uploaded as a CSV file.\n\n"
 	helpText += TICK + "remove me from <resource>" + TICK + " This will remove the user from the queue for a resource.\n\n"
 	helpText += TICK + "remove resource <resource>" + TICK + " This will remove an empty resource.\n\n"
 	helpText += TICK + "clear <resource>" + TICK + " This will clear the queue for a given resource and release it.\n\n"
@@ -740,6 +1151,12 @@ func (h *Handler) help(ea *EventAction) error {
 	if h.HasAdminAccess(u.Name) {
 		helpText += TICK + "prune <resource>" + TICK + " This will clear all unreserved resources from memory.\n\n"
 		helpText += TICK + "kick <@user>" + TICK + " This will kick the mentioned user from _all_ resources they are holding. As the user is kicked from each resource, the queue will be advanced to the next user waiting.\n\n"
+		helpText += TICK + "limit <resource> <duration|off>" + TICK + " This will set how long a resource can be held before it is released automatically.\n\n"
+		helpText += TICK + "move <@user> to <position> in <resource>" + TICK + " This will move the mentioned user to a position in the queue for a resource, where 1 is the front.\n\n"
+		helpText += TICK + "swap <@user> <@user> in <resource>" + TICK + " This will swap the places of the mentioned users in the queue for a resource.\n\n"
+		helpText += TICK + "deadlocks" + TICK + " This will list every group of users who are each waiting for a resource held by another.\n\n"
+		helpText += TICK + "capacity <resource> <count>" + TICK + " This will set how many users can hold a resource at once.\n\n"
+		helpText += TICK + "extensions <resource> <count|off>" + TICK + " This will cap how many times a hold on a resource can be extended.\n\n"
 		helpText += TICK + "nuke" + TICK + " This will clear all reservations and all queues for all resources. This can only be done from a public channel, not a DM. There is no confirmation, so be careful.\n\n"
 	}
 
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"regexp"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+)
+
+var behalfRegex = regexp.MustCompile(`^(.+?)\s+for\s+\<\@([a-zA-Z0-9]+)\>$`)
+
+// parseBehalf splits an optional "for <@user>" suffix from a resource list. The ID of the user is empty if
+// there is none.
+func parseBehalf(text string) (string, string) {
+	matches := behalfRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return text, ""
+	}
+	return matches[1], matches[2]
+}
+
+// canReserveFor returns whether a user may reserve resources on behalf of someone else. Admins can reserve
+// anything, and env owners can reserve within their envs.
+func (h *Handler) canReserveFor(u *models.User, resources []*models.Resource) bool {
+	if h.HasAdminAccess(u.Name) {
+		return true
+	}
+	for _, r := range resources {
+		if !util.InSlice(h.owners[r.Env], u.Name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,605 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bookingTimeFormat = "Mon Jan 2 15:04"
+	// bookingConflictHorizon is how far ahead occurrences of recurring bookings are checked for conflicts
+	bookingConflictHorizon = 4 * 7 * 24 * time.Hour
+	// bookingWarning is how soon a booking has to start for users who queue for its resource to be warned
+	bookingWarning = 12 * time.Hour
+)
+
+var weekdays = map[string][]time.Weekday{
+	"day":     {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekday": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend": {time.Saturday, time.Sunday},
+	"sun":     {time.Sunday},
+	"mon":     {time.Monday},
+	"tue":     {time.Tuesday},
+	"wed":     {time.Wednesday},
+	"thu":     {time.Thursday},
+	"fri":     {time.Friday},
+	"sat":     {time.Saturday},
+}
+
+// parseDays parses a comma-separated list of days, such as "weekday" or "mon,wed,fri", into the days of the
+// week they include
+func parseDays(text string) ([]time.Weekday, error) {
+	set := map[time.Weekday]bool{}
+	for _, part := range strings.Split(strings.ToLower(text), ",") {
+		part = strings.TrimSuffix(strings.TrimSpace(part), "s")
+		days, ok := weekdays[part]
+		if !ok && len(part) > 3 {
+			// Full day names, e.g. "monday"
+			if d, found := weekdays[part[:3]]; found && strings.HasSuffix(part, "day") {
+				days, ok = d, true
+			}
+		}
+		if !ok {
+			return nil, e.InvalidTime
+		}
+		for _, d := range days {
+			set[d] = true
+		}
+	}
+
+	ret := []time.Weekday{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if set[d] {
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
+
+// formatDays returns the shortest name for a set of days as accepted by parseDays
+func formatDays(days []time.Weekday) string {
+	for _, name := range []string{"day", "weekday", "weekend"} {
+		if len(weekdays[name]) != len(days) {
+			continue
+		}
+		b := &models.Booking{Every: days}
+		match := true
+		for _, d := range weekdays[name] {
+			match = match && b.RepeatsOn(d)
+		}
+		if match {
+			return name
+		}
+	}
+
+	names := []string{}
+	for _, d := range days {
+		names = append(names, strings.ToLower(d.String()[:3]))
+	}
+	return strings.Join(names, ",")
+}
+
+// parseBookingTime parses a time given as "2006-01-02 15:04", "15:04", "today 15:04" or "tomorrow 15:04" in
+// the local time zone. A bare clock time is on the same day as base. The returned bool reports whether the
+// day was given explicitly.
+func parseBookingTime(text string, base time.Time) (time.Time, bool, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	now := time.Now()
+
+	t, err := time.ParseInLocation("2006-01-02 15:04", text, time.Local)
+	if err == nil {
+		return t, true, nil
+	}
+
+	day, explicit := base, false
+	switch {
+	case strings.HasPrefix(text, "today "):
+		day, explicit = now, true
+		text = strings.TrimPrefix(text, "today ")
+	case strings.HasPrefix(text, "tomorrow "):
+		day, explicit = now.AddDate(0, 0, 1), true
+		text = strings.TrimPrefix(text, "tomorrow ")
+	}
+
+	clock, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return time.Time{}, false, e.InvalidTime
+	}
+	y, m, d := day.Date()
+	return time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, time.Local), explicit, nil
+}
+
+// newBookingID returns a short random ID that is not used by any existing booking
+func newBookingID(existing []*models.Booking) string {
+	for {
+		b := make([]byte, 3)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+
+		used := false
+		for _, bk := range existing {
+			if bk.ID == id {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return id
+		}
+	}
+}
+
+// getBookingText returns a line describing a booking
+func (h *Handler) getBookingText(b *models.Booking) string {
+	end := b.End.Format("15:04")
+	if b.End.YearDay() != b.Start.YearDay() || b.End.Year() != b.Start.Year() {
+		end = b.End.Format(bookingTimeFormat)
+	}
+
+	ret := ""
+	if b.Recurring() {
+		ret = fmt.Sprintf(msgBookingXByYEveryZFromVToW, b.ID, h.getUserDisplay(b.User, false), formatDays(b.Every), b.Start.Format("15:04"), b.End.Format("15:04"), b.Start.Format(bookingTimeFormat))
+	} else {
+		ret = fmt.Sprintf(msgBookingXByYFromZToW, b.ID, h.getUserDisplay(b.User, false), b.Start.Format(bookingTimeFormat), end)
+	}
+	switch {
+	case b.Active:
+		ret += msgSpaceNow
+	case b.Paused:
+		ret += msgSpacePaused
+	}
+	return ret
+}
+
+// getRecurringText returns a line for every recurring booking of a resource
+func (h *Handler) getRecurringText(r *models.Resource) string {
+	ret := ""
+	for _, b := range h.data.GetBookings() {
+		if b.Recurring() && b.Key() == r.Key() {
+			ret += fmt.Sprintf(msgNewlineBookedX, h.getBookingText(b))
+		}
+	}
+	return ret
+}
+
+// getUpcomingBookingText returns a warning about the next booking of a resource by someone other than the
+// user, if it starts soon
+func (h *Handler) getUpcomingBookingText(u *models.User, r *models.Resource) string {
+	soon := time.Now().Add(bookingWarning)
+	for _, b := range h.data.GetBookings() {
+		if b.Key() != r.Key() || b.User.ID == u.ID || b.Paused || !b.Start.Before(soon) {
+			continue
+		}
+		return fmt.Sprintf(msgHeadsUpYIsBookedByXFromZToW, r, h.getUserDisplay(b.User, false), b.Start.Format(bookingTimeFormat), b.End.Format("15:04"))
+	}
+	return ""
+}
+
+// getOwnBooking returns the booking with an ID if the user made it or is an admin. Otherwise, it replies
+// with why not and returns nil.
+func (h *Handler) getOwnBooking(ea *EventAction, u *models.User, id string) *models.Booking {
+	for _, b := range h.data.GetBookings() {
+		if b.ID != id {
+			continue
+		}
+		if b.User.ID != u.ID && !h.HasAdminAccess(u.Name) {
+			h.errorReply(ea, fmt.Sprintf(msgBookingXIsNotYours, id))
+			return nil
+		}
+		return b
+	}
+
+	h.errorReply(ea, fmt.Sprintf(msgBookingXDoesNotExist, id))
+	return nil
+}
+
+// book reserves a resource for the user during a window of time, or during the same window on every given
+// day. Bookings of a resource may overlap as long as there are never more of them than the resource's
+// capacity.
+func (h *Handler) book(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	res, err := h.parseResource(strings.Trim(matches[0], " `"))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	var every []time.Weekday
+	from, to := matches[1], matches[2]
+	if ea.Action == "book_every" || ea.Action == "book_every_dm" {
+		every, err = parseDays(matches[1])
+		if err != nil {
+			h.errorReply(ea, msgInvalidDays)
+			return nil
+		}
+		from, to = matches[2], matches[3]
+	}
+
+	now := time.Now()
+	start, _, err := parseBookingTime(from, now)
+	if err != nil {
+		h.errorReply(ea, msgInvalidTime)
+		return nil
+	}
+	end, explicit, err := parseBookingTime(to, start)
+	if err != nil {
+		h.errorReply(ea, msgInvalidTime)
+		return nil
+	}
+	// A window such as "from 22:00 to 02:00" ends on the next day
+	if !explicit && !end.After(start) {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		h.errorReply(ea, msgBookingMustEndAfterStart)
+		return nil
+	}
+
+	b := &models.Booking{
+		User:  u,
+		Name:  res.Name,
+		Env:   res.Env,
+		Start: start,
+		End:   end,
+		Every: every,
+	}
+	if b.Recurring() {
+		// The first occurrence is the next one that has not started yet
+		for !b.RepeatsOn(b.Start.Weekday()) || b.Start.Before(now) {
+			b.Next()
+		}
+	} else if start.Before(now.Add(-time.Minute)) {
+		h.errorReply(ea, msgBookingMustStartInFuture)
+		return nil
+	}
+
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	// The capacity is read under the lock, so that it can't change between the check and adding the booking
+	capacity := 1
+	if r := h.data.GetResource(res.Name, res.Env, false); r != nil {
+		capacity = r.Holders()
+	}
+
+	existing := h.data.GetBookings()
+	if conflicts := h.getConflicts(b, existing, capacity); len(conflicts) > 0 {
+		h.errorReply(ea, fmt.Sprintf(msgYIsAlreadyBookedX, res, strings.Join(conflicts, "\n")))
+		return nil
+	}
+
+	// Booked resources are created so that they show up in the status
+	err = h.data.Create(res.Name, res.Env)
+	if err != nil {
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	b.ID = newBookingID(existing)
+	err = h.data.AddBooking(b)
+	if err != nil {
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgYouHaveBookedYZ, res, h.getBookingText(b)), true)
+}
+
+// getConflicts returns the bookings that would leave no room for b during any of its occurrences, which is
+// when as many of them as the resource's capacity overlap each other at some point in its window. Recurring
+// bookings are only checked up to bookingConflictHorizon ahead.
+func (h *Handler) getConflicts(b *models.Booking, existing []*models.Booking, capacity int) []string {
+	for _, o := range b.Occurrences(b.Start.Add(bookingConflictHorizon)) {
+		// Each occurrence that overlaps o, along with the booking it is an occurrence of
+		overlapping := []*models.Booking{}
+		of := map[*models.Booking]*models.Booking{}
+		for _, other := range existing {
+			if other.Key() != b.Key() {
+				continue
+			}
+			for _, oo := range other.Occurrences(o.End) {
+				if oo.Overlaps(o.Start, o.End) {
+					overlapping = append(overlapping, oo)
+					of[oo] = other
+				}
+			}
+		}
+
+		peak := peakOverlap(o, overlapping)
+		if len(peak) >= capacity {
+			conflicts := []string{}
+			for _, oo := range peak {
+				conflicts = append(conflicts, h.getBookingText(of[oo]))
+			}
+			return conflicts
+		}
+	}
+	return nil
+}
+
+// peakOverlap returns the largest set of bookings that are all under way at the same point in the window of
+// b. That point is either the start of b or the start of one of the bookings.
+func peakOverlap(b *models.Booking, bookings []*models.Booking) []*models.Booking {
+	points := []time.Time{b.Start}
+	for _, other := range bookings {
+		if other.Start.After(b.Start) && other.Start.Before(b.End) {
+			points = append(points, other.Start)
+		}
+	}
+
+	peak := []*models.Booking{}
+	for _, t := range points {
+		at := []*models.Booking{}
+		for _, other := range bookings {
+			if !other.Start.After(t) && t.Before(other.End) {
+				at = append(at, other)
+			}
+		}
+		if len(at) > len(peak) {
+			peak = at
+		}
+	}
+	return peak
+}
+
+// bookings lists every booking, or only those of the user for `my bookings`
+func (h *Handler) bookings(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	userOnly := ea.Action == "my_bookings" || ea.Action == "my_bookings_dm"
+
+	lines := []string{}
+	for _, b := range h.data.GetBookings() {
+		if userOnly && b.User.ID != u.ID {
+			continue
+		}
+		r := &models.Resource{Name: b.Name, Env: b.Env}
+		lines = append(lines, fmt.Sprintf(msgYColonX, r, h.getBookingText(b)))
+	}
+	if len(lines) == 0 {
+		return h.reply(ea, msgNoBookings, userOnly)
+	}
+
+	return h.reply(ea, strings.Join(lines, "\n"), userOnly)
+}
+
+// pause pauses or resumes a recurring booking. Pausing skips its occurrences, including the current one if
+// it has not started, until it is resumed.
+func (h *Handler) pause(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	id := strings.Trim(matches[0], " `")
+	paused := ea.Action == "pause" || ea.Action == "pause_dm"
+
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	b := h.getOwnBooking(ea, u, id)
+	if b == nil {
+		return nil
+	}
+	if !b.Recurring() {
+		h.errorReply(ea, fmt.Sprintf(msgBookingXIsNotRecurring, id))
+		return nil
+	}
+
+	b.Paused = paused
+	err = h.data.UpdateBooking(b)
+	if err != nil {
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	msg := fmt.Sprintf(msgBookingXResumed, id)
+	if paused {
+		msg = fmt.Sprintf(msgBookingXPaused, id)
+	}
+	return h.reply(ea, msg, false)
+}
+
+// calendar lists the current and upcoming bookings of a resource
+func (h *Handler) calendar(ea *EventAction) error {
+	ev := ea.Event
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	res, err := h.parseResource(strings.Trim(matches[0], " `"))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	lines := []string{}
+	for _, b := range h.data.GetBookings() {
+		if b.Key() == res.Key() {
+			lines = append(lines, h.getBookingText(b))
+		}
+	}
+	if len(lines) == 0 {
+		return h.reply(ea, fmt.Sprintf(msgNoBookingsForY, res), false)
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgBookingsForYX, res, strings.Join(lines, "\n")), false)
+}
+
+// unbook cancels a booking. Only the user who made it or an admin can cancel it.
+func (h *Handler) unbook(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	id := strings.Trim(matches[0], " `")
+
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	b := h.getOwnBooking(ea, u, id)
+	if b == nil {
+		return nil
+	}
+
+	err = h.data.RemoveBooking(b.ID)
+	if err != nil {
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgBookingXCancelled, id), false)
+}
+
+// settleBookings enforces bookings. When a booking starts, its user is moved ahead of everyone else in line
+// and anyone who loses their hold because of it is notified. When it ends, the user is removed from the
+// queue and the booking is deleted, or moved to its next occurrence if it is recurring.
+func (h *Handler) settleBookings() {
+	now := time.Now()
+
+	for _, b := range h.data.GetBookings() {
+		res := &models.Resource{Name: b.Name, Env: b.Env}
+
+		switch {
+		case !now.Before(b.End):
+			if b.Active {
+				h.endBooking(b, res)
+			}
+			if !b.Recurring() {
+				err := h.data.RemoveBooking(b.ID)
+				if err != nil {
+					log.Errorf("%+v", err)
+				}
+				continue
+			}
+
+			for !now.Before(b.End) {
+				b.Next()
+			}
+			b.Active = false
+			err := h.data.UpdateBooking(b)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		case !now.Before(b.Start) && !b.Active && !b.Paused:
+			h.startBooking(b, res)
+		}
+	}
+}
+
+func (h *Handler) startBooking(b *models.Booking, res *models.Resource) {
+	held := map[string]*models.User{}
+	if q, err := h.data.GetQueueForResource(res.Name, res.Env); err == nil {
+		for _, r := range q.Holders() {
+			held[r.User.ID] = r.User
+		}
+	}
+
+	err := h.watchQueue(res.Name, res.Env, func() error {
+		err := h.data.Reserve(b.User, res.Name, res.Env)
+		if err != nil && err != e.AlreadyInQueue {
+			return err
+		}
+		return h.data.Move(b.User, res.Name, res.Env, 1)
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+
+	// The hold ends with the booking, whatever the limits are
+	err = h.data.UpdateReservation(b.User, res.Name, res.Env, func(r *models.Reservation) error {
+		r.Hold = b.End.Sub(r.Time)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	b.Active = true
+	err = h.data.UpdateBooking(b)
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+	log.Infof("Booking %s of %s by %s started", b.ID, res, b.User.Name)
+
+	if _, ok := held[b.User.ID]; !ok {
+		err = h.sendDM(b.User, fmt.Sprintf(msgYourBookingOfYStartedUntilZ, res, b.End.Format("15:04")))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+
+	q, err := h.data.GetQueueForResource(res.Name, res.Env)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+	for _, r := range q.Holders() {
+		delete(held, r.User.ID)
+	}
+	for _, u := range held {
+		err = h.sendDM(u, fmt.Sprintf(msgXsBookingOfYStartedBackInLine, h.getUserDisplay(b.User, false), res))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+func (h *Handler) endBooking(b *models.Booking, res *models.Resource) {
+	var cu *models.Reservation
+	if h.getHeldReservation(b.User, res.Name, res.Env) != nil {
+		cu = h.getNextInLine(res.Name, res.Env)
+	}
+	err := h.watchQueue(res.Name, res.Env, func() error {
+		return h.data.Remove(b.User, res.Name, res.Env)
+	})
+	if err == e.NotInQueue || err == e.ResourceDoesNotExist {
+		// The user released it early or their hold already expired
+		return
+	}
+	if err != nil {
+		log.Errorf("%+v", err)
+		return
+	}
+	log.Infof("Booking %s of %s by %s ended", b.ID, res, b.User.Name)
+
+	err = h.sendDM(b.User, fmt.Sprintf(msgYourBookingOfYEnded, res))
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+	if cu != nil {
+		err = h.sendDM(cu.User, fmt.Sprintf(msgXsBookingOfYEndedItIsYours, h.getUserDisplay(b.User, false), res))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
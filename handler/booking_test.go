@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// window returns a booking with the given ID from one hour of the day to another
+func window(id string, from, to int) *models.Booking {
+	day := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	return &models.Booking{ID: id, Start: day.Add(time.Duration(from) * time.Hour), End: day.Add(time.Duration(to) * time.Hour)}
+}
+
+func TestPeakOverlap(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        *models.Booking
+		bookings []*models.Booking
+		want     string
+	}{
+		{"none", window("new", 9, 17), nil, ""},
+		{"apart", window("new", 9, 17), []*models.Booking{window("a", 9, 10), window("b", 11, 12)}, "a"},
+		{"back to back", window("new", 9, 17), []*models.Booking{window("a", 9, 11), window("b", 11, 12)}, "a"},
+		{"together", window("new", 9, 17), []*models.Booking{window("a", 9, 12), window("b", 11, 13), window("c", 14, 15)}, "a,b"},
+		{"before the window", window("new", 12, 17), []*models.Booking{window("a", 9, 13), window("b", 10, 14)}, "a,b"},
+		{"later in the window", window("new", 9, 17), []*models.Booking{window("a", 9, 10), window("b", 13, 16), window("c", 14, 15), window("d", 15, 17)}, "b,c"},
+	}
+	for _, tt := range tests {
+		ids := []string{}
+		for _, b := range peakOverlap(tt.b, tt.bookings) {
+			ids = append(ids, b.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("%s: peak is %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		err  error
+	}{
+		{"day", "[Sunday Monday Tuesday Wednesday Thursday Friday Saturday]", nil},
+		{"weekday", "[Monday Tuesday Wednesday Thursday Friday]", nil},
+		{"weekend", "[Sunday Saturday]", nil},
+		{"mon,wed,fri", "[Monday Wednesday Friday]", nil},
+		{"fri, mon", "[Monday Friday]", nil},
+		{"Mondays, Thursday", "[Monday Thursday]", nil},
+		{"weekends,mon", "[Sunday Monday Saturday]", nil},
+		{"tue,tue", "[Tuesday]", nil},
+		{"funday", "[]", e.InvalidTime},
+		{"mo", "[]", e.InvalidTime},
+		{"mon,", "[]", e.InvalidTime},
+	}
+	for _, tt := range tests {
+		days, err := parseDays(tt.text)
+		if got := fmt.Sprint(days); got != tt.want || err != tt.err {
+			t.Errorf("%q: got %s, %v, want %s, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseBookingTime(t *testing.T) {
+	base := time.Date(2021, time.June, 1, 8, 0, 0, 0, time.Local)
+	at := func(day time.Time, hour, min int) time.Time {
+		y, m, d := day.Date()
+		return time.Date(y, m, d, hour, min, 0, 0, time.Local)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		text     string
+		want     time.Time
+		explicit bool
+		err      error
+	}{
+		{"14:00", at(base, 14, 0), false, nil},
+		{" 9:30 ", at(base, 9, 30), false, nil},
+		{"2021-03-14 14:00", time.Date(2021, time.March, 14, 14, 0, 0, 0, time.Local), true, nil},
+		{"today 14:00", at(now, 14, 0), true, nil},
+		{"Tomorrow 09:15", at(now.AddDate(0, 0, 1), 9, 15), true, nil},
+		{"25:00", time.Time{}, false, e.InvalidTime},
+		{"tomorrow", time.Time{}, false, e.InvalidTime},
+		{"next week", time.Time{}, false, e.InvalidTime},
+		{"2021-03-14", time.Time{}, false, e.InvalidTime},
+	}
+	for _, tt := range tests {
+		got, explicit, err := parseBookingTime(tt.text, base)
+		if !got.Equal(tt.want) || explicit != tt.explicit || err != tt.err {
+			t.Errorf("%q: got %s, %v, %v, want %s, %v, %v", tt.text, got, explicit, err, tt.want, tt.explicit, tt.err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// composite is a pending request for several resources that is only granted once all of them are free. It is
+// stored as a pending reservation in the queue of each of its resources, so it keeps its place in all of them.
+type composite struct {
+	User      *models.User
+	Resources []*models.Resource
+	Time      time.Time
+	Note      string
+	// found is how many of its pending reservations are still queued, and held how many of those are holders
+	found int
+	held  int
+}
+
+func (c *composite) String() string {
+	names := []string{}
+	for _, r := range c.Resources {
+		names = append(names, TICK+r.String()+TICK)
+	}
+	return strings.Join(names, ", ")
+}
+
+// reserveAll queues the user for every resource in the list at once, holding none of them until all of them
+// are free. If they already are, the user gets them right away.
+func (h *Handler) reserveAll(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	list, note := parseNote(matches[0])
+	list, hold, err := parseHold(list)
+	if err != nil {
+		h.errorReply(ea, msgInvalidDuration)
+		return err
+	}
+	resources, err := h.getResourcesFromCommaList(list)
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	err = h.watchQueues(resources, func() error {
+		return h.data.ReserveAll(u, resources)
+	})
+	if err != nil {
+		if err == e.AlreadyInQueue {
+			h.errorReply(ea, msgAlreadyInLineForSomeOfThem)
+			return nil
+		}
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	c := &composite{User: u, Note: note, Resources: resources}
+	if hold > 0 || note != "" {
+		for _, r := range resources {
+			err = h.data.UpdateReservation(u, r.Name, r.Env, func(res *models.Reservation) error {
+				res.Hold = hold
+				res.Note = note
+				return nil
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+
+	if h.data.GrantAll(u, resources) != nil {
+		return h.reply(ea, fmt.Sprintf(msgYouWillGetAllOfYWhenFree, c), true)
+	}
+	return h.reply(ea, fmt.Sprintf(msgYouCurrentlyHaveAllOfY, c), true)
+}
+
+// getComposites returns the pending composite requests, oldest first, or only those of a user if set
+func (h *Handler) getComposites(u *models.User) []*composite {
+	byKey := map[string]*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		byKey[r.Key()] = r
+	}
+
+	found := map[string]*composite{}
+	for _, q := range h.data.GetQueues() {
+		for i, res := range q.Reservations {
+			if !res.Pending() || (u != nil && res.User.ID != u.ID) {
+				continue
+			}
+
+			id := res.User.ID + " " + strings.Join(res.Composite, ",")
+			c, ok := found[id]
+			if !ok {
+				c = &composite{User: res.User, Time: res.Time, Note: res.Note}
+				for _, key := range res.Composite {
+					if r, ok := byKey[key]; ok {
+						c.Resources = append(c.Resources, r)
+					}
+				}
+				found[id] = c
+			}
+			if res.Time.Before(c.Time) {
+				c.Time = res.Time
+			}
+			c.found++
+			if i < len(q.Holders()) {
+				c.held++
+			}
+		}
+	}
+
+	ret := []*composite{}
+	for _, c := range found {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Time.Before(ret[j].Time)
+	})
+	return ret
+}
+
+// settleComposites grants pending composite requests, oldest first, once the user is a holder of all of their
+// resources. Requests that lost any of their reservations, because the user was kicked or a queue was cleared,
+// are cancelled.
+func (h *Handler) settleComposites() {
+	for _, c := range h.getComposites(nil) {
+		if c.found < len(c.Resources) || len(c.Resources) == 0 {
+			h.cancelComposite(c)
+			err := h.sendDM(c.User, fmt.Sprintf(msgYouAreNoLongerWaitingForAllOfY, c))
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+			continue
+		}
+		if c.held < len(c.Resources) {
+			continue
+		}
+
+		err := h.data.GrantAll(c.User, c.Resources)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		err = h.sendDM(c.User, fmt.Sprintf(msgYouNowHaveAllOfY, c))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+// cancelComposite removes the user from the queues of every resource of a pending composite request
+func (h *Handler) cancelComposite(c *composite) {
+	for _, r := range c.Resources {
+		err := h.watchQueue(r.Name, r.Env, func() error {
+			return h.data.Remove(c.User, r.Name, r.Env)
+		})
+		if err != nil && err != e.NotInQueue {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+// removeComposites cancels the user's pending composite requests that include a resource and returns them
+func (h *Handler) removeComposites(u *models.User, r *models.Resource) []*composite {
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	removed := []*composite{}
+	for _, c := range h.getComposites(u) {
+		for _, res := range c.Resources {
+			if res.Key() == r.Key() {
+				h.cancelComposite(c)
+				removed = append(removed, c)
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// getCompositesText returns a line for every pending composite request, or only those of a user if set
+func (h *Handler) getCompositesText(u *models.User) string {
+	ret := ""
+	for _, c := range h.getComposites(u) {
+		note := ""
+		if c.Note != "" {
+			note = fmt.Sprintf(msgColonNoteX, c.Note)
+		}
+		ret += fmt.Sprintf(msgXIsWaitingForAllOfY, h.getUserDisplay(c.User, false), getDuration(c.Time), c, note) + "\n"
+	}
+	return ret
+}
+
+// getPendingText returns the text marking a holder's place that is set aside for a pending composite request
+func getPendingText(res *models.Reservation) string {
+	if !res.Pending() {
+		return ""
+	}
+	return msgSpaceSetAsideForAllOrNothing
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// waitFor is an edge of the wait-for graph. User is waiting for Resource, which is held by Holder.
+type waitFor struct {
+	User     *models.User
+	Resource *models.Resource
+	Holder   *models.User
+}
+
+// deadlock is a cycle in the wait-for graph. Each user is waiting for a resource held by the next, and the
+// last is waiting for a resource held by the first.
+type deadlock []*waitFor
+
+func (d deadlock) involves(u *models.User) bool {
+	for _, w := range d {
+		if w.User.ID == u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// getWaitForGraph builds the wait-for graph from the current queues, keyed by the ID of the waiting user.
+// Waiting for a pool is left out, since any of its members will do.
+func (h *Handler) getWaitForGraph() map[string][]*waitFor {
+	graph := map[string][]*waitFor{}
+	for _, q := range h.data.GetQueues() {
+		for _, waiting := range q.Waiting() {
+			if waiting.Pool != "" {
+				continue
+			}
+			for _, holder := range q.Holders() {
+				if holder.User.ID == waiting.User.ID {
+					continue
+				}
+				graph[waiting.User.ID] = append(graph[waiting.User.ID], &waitFor{
+					User:     waiting.User,
+					Resource: q.Resource,
+					Holder:   holder.User,
+				})
+			}
+		}
+	}
+	return graph
+}
+
+// findDeadlocks returns every cycle in the wait-for graph. Each cycle is returned once, starting with the
+// user with the lowest ID.
+func (h *Handler) findDeadlocks() []deadlock {
+	graph := h.getWaitForGraph()
+
+	users := []string{}
+	for id := range graph {
+		users = append(users, id)
+	}
+	sort.Strings(users)
+
+	ret := []deadlock{}
+	for _, start := range users {
+		// Only users with a higher ID than start are visited, so each cycle is only found from its lowest user
+		path := deadlock{}
+		onPath := map[string]bool{start: true}
+		var visit func(id string)
+		visit = func(id string) {
+			for _, w := range graph[id] {
+				next := w.Holder.ID
+				path = append(path, w)
+				switch {
+				case next == start:
+					ret = append(ret, append(deadlock{}, path...))
+				case next > start && !onPath[next]:
+					onPath[next] = true
+					visit(next)
+					onPath[next] = false
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		visit(start)
+	}
+
+	return ret
+}
+
+// getDeadlockText describes a deadlock
+func (h *Handler) getDeadlockText(d deadlock) string {
+	parts := []string{}
+	for _, w := range d {
+		parts = append(parts, fmt.Sprintf(msgXWaitsForYHeldByZ, h.getUserDisplay(w.User, false), w.Resource, h.getUserDisplay(w.Holder, false)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// warnDeadlocks warns about every deadlock that any of the affected users is involved in after requester
+// changed their queues. The requester is warned in the reply and the other users involved are warned via DM.
+func (h *Handler) warnDeadlocks(ea *EventAction, requester *models.User, affected ...*models.User) {
+	for _, d := range h.findDeadlocks() {
+		involved := false
+		for _, u := range affected {
+			if d.involves(u) {
+				involved = true
+			}
+		}
+		if !involved {
+			continue
+		}
+
+		text := h.getDeadlockText(d)
+		h.reply(ea, fmt.Sprintf(msgYourReservationCausedDeadlockX, text), true)
+
+		warned := map[string]bool{requester.ID: true}
+		for _, w := range d {
+			if warned[w.User.ID] {
+				continue
+			}
+			warned[w.User.ID] = true
+			err := h.sendDM(w.User, fmt.Sprintf(msgYouAreInDeadlockX, text))
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+}
+
+func (h *Handler) deadlocks(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `deadlocks`.", false)
+		return nil
+	}
+
+	resp := ""
+	for _, d := range h.findDeadlocks() {
+		resp += h.getDeadlockText(d) + "\n"
+	}
+	if resp == "" {
+		resp = msgNoDeadlocks
+	}
+	h.reply(ea, resp, false)
+
+	return nil
+}
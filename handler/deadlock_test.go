@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// line is a queue of a resource in qa, given as the users in it in order. Users marked with * are waiting
+// for a pool.
+type line struct {
+	name     string
+	capacity int
+	users    string
+}
+
+func TestFindDeadlocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []line
+		want  string
+	}{
+		{"none", []line{{"x", 1, "a,b"}}, ""},
+		{"chain", []line{{"x", 1, "a,b"}, {"y", 1, "b,c"}}, ""},
+		{"two users", []line{{"x", 1, "a,b"}, {"y", 1, "b,a"}}, "a>y>b b>x>a"},
+		{"three users", []line{{"x", 1, "a,b"}, {"y", 1, "b,c"}, {"z", 1, "c,a"}}, "a>z>c c>y>b b>x>a"},
+		{"pool", []line{{"x", 1, "a,b"}, {"y", 1, "b,a*"}}, ""},
+		{"shared holder", []line{{"x", 1, "a,b,c"}, {"y", 1, "b,a"}, {"z", 1, "c,a"}}, "a>y>b b>x>a; a>z>c c>x>a"},
+		{"capacity", []line{{"x", 2, "a,b,c"}, {"y", 1, "c,a"}}, "a>y>c c>x>a"},
+		{"holding both", []line{{"x", 2, "a,b"}, {"y", 1, "b,a"}}, ""},
+	}
+	for _, tt := range tests {
+		m := data.NewMemory()
+		h := &Handler{data: m}
+		for _, l := range tt.lines {
+			if err := m.Create(l.name, "qa"); err != nil {
+				t.Fatal(err)
+			}
+			capacity := l.capacity
+			err := m.UpdateResource(l.name, "qa", func(r *models.Resource) error {
+				r.Capacity = capacity
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range strings.Split(l.users, ",") {
+				pool := strings.HasSuffix(name, "*")
+				name = strings.TrimSuffix(name, "*")
+				u := &models.User{ID: name, Name: name}
+				if err := m.Reserve(u, l.name, "qa"); err != nil {
+					t.Fatal(err)
+				}
+				if !pool {
+					continue
+				}
+				err := m.UpdateReservation(u, l.name, "qa", func(res *models.Reservation) error {
+					res.Pool = "any"
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		found := []string{}
+		for _, d := range h.findDeadlocks() {
+			edges := []string{}
+			for _, w := range d {
+				edges = append(edges, w.User.ID+">"+w.Resource.Name+">"+w.Holder.ID)
+			}
+			found = append(found, strings.Join(edges, " "))
+		}
+		sort.Strings(found)
+		if got := strings.Join(found, "; "); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
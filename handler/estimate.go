@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// estimateWindow is how far back holds are averaged to estimate waits
+const estimateWindow = 30 * 24 * time.Hour
+
+// endedHold is a hold of a resource that ended at a given time
+type endedHold struct {
+	key    string
+	at     time.Time
+	length time.Duration
+}
+
+// holdCache keeps the holds that ended within the estimate window, so that only the events since it was last
+// updated need to be read
+type holdCache struct {
+	lock sync.Mutex
+	// updated is the time of the last event read, and seen is how many of the events at that time were read.
+	// More events can be recorded at the same time after the cache is updated, so the events are counted
+	// rather than skipped by time.
+	updated time.Time
+	seen    int
+	holds   []*endedHold
+}
+
+// getAverageHolds returns how long holds that ended within the estimate window lasted on average, keyed by
+// resource key. Resources without any such holds are left out.
+func (h *Handler) getAverageHolds() map[string]time.Duration {
+	c := &h.holds
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cutoff := time.Now().Add(-estimateWindow)
+	since := c.updated
+	if since.Before(cutoff) {
+		since = cutoff
+	}
+	// Events are returned in the order they were recorded, so the ones at the time of the last update that were
+	// already read come first
+	skip := c.seen
+	for _, ev := range h.data.GetEvents(since) {
+		switch {
+		case ev.Time.Before(c.updated):
+			continue
+		case ev.Time.Equal(c.updated):
+			if skip > 0 {
+				skip--
+				continue
+			}
+			c.seen++
+		default:
+			c.updated = ev.Time
+			c.seen = 1
+		}
+		if ev.Held > 0 {
+			c.holds = append(c.holds, &endedHold{key: models.ResourceKey(ev.Name, ev.Env), at: ev.Time, length: ev.Held})
+		}
+	}
+
+	kept := []*endedHold{}
+	totals := map[string]time.Duration{}
+	counts := map[string]int{}
+	for _, hold := range c.holds {
+		if hold.at.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, hold)
+		totals[hold.key] += hold.length
+		counts[hold.key]++
+	}
+	c.holds = kept
+
+	ret := map[string]time.Duration{}
+	for key, total := range totals {
+		ret[key] = total / time.Duration(counts[key])
+	}
+	return ret
+}
+
+// estimateWait returns how long the user will likely wait for a resource, or false if they hold it or there is
+// nothing to base an estimate on. Everyone is expected to hold the resource for the average hold, but no longer
+// than their hold limit, so holders are expected to let go after what is left of that and each user waiting
+// ahead takes the next place to free up.
+func (h *Handler) estimateWait(u *models.User, name, env string, average time.Duration) (time.Duration, bool) {
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil {
+		return 0, false
+	}
+	idx := q.Find(u)
+	holders := q.Holders()
+	if idx < len(holders) {
+		return 0, false
+	}
+
+	expected := func(res *models.Reservation) (time.Duration, bool) {
+		limit := h.holdLimit(res)
+		if limit > 0 {
+			limit += res.Extended
+		}
+		switch {
+		case average > 0 && (limit <= 0 || average < limit):
+			return average, true
+		case limit > 0:
+			return limit, true
+		}
+		return 0, false
+	}
+
+	// free is how long until each place among the holders frees up
+	now := time.Now()
+	free := []time.Duration{}
+	for _, res := range holders {
+		d, ok := expected(res)
+		if !ok {
+			return 0, false
+		}
+		left := d - now.Sub(res.Time)
+		if left < 0 {
+			left = 0
+		}
+		free = append(free, left)
+	}
+	for _, res := range q.Reservations[len(holders):idx] {
+		d, ok := expected(res)
+		if !ok {
+			return 0, false
+		}
+		sort.Slice(free, func(i, j int) bool {
+			return free[i] < free[j]
+		})
+		free[0] += d
+	}
+	sort.Slice(free, func(i, j int) bool {
+		return free[i] < free[j]
+	})
+	return free[0], true
+}
+
+// getEstimateText returns the text estimating when the user will get a resource they are waiting for, if
+// there is an estimate. averages are the average holds from getAverageHolds.
+func (h *Handler) getEstimateText(u *models.User, r *models.Resource, averages map[string]time.Duration) string {
+	d, ok := h.estimateWait(u, r.Name, r.Env, averages[r.Key()])
+	if !ok {
+		return ""
+	}
+	if d < time.Minute {
+		return msgSpaceYouShouldGetItSoon
+	}
+	return fmt.Sprintf(msgSpaceYouShouldGetItInAboutXAroundY, formatDuration(d), time.Now().Add(d).Format(bookingTimeFormat))
+}
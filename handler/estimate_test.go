@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// history is a store whose events are given by the test
+type history struct {
+	data.Manager
+	events []*models.Event
+}
+
+func (m *history) GetEvents(since time.Time) []*models.Event {
+	ret := []*models.Event{}
+	for _, ev := range m.events {
+		if !ev.Time.Before(since) {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+func TestGetAverageHoldsEventsAtTheSameTime(t *testing.T) {
+	now := time.Now()
+	released := func(at time.Time, held time.Duration) *models.Event {
+		return &models.Event{Type: models.Released, Time: at, Name: "web", Env: "qa", Held: held}
+	}
+	m := &history{}
+	h := &Handler{data: m}
+
+	steps := []struct {
+		name  string
+		event *models.Event
+		want  time.Duration
+	}{
+		{"first", released(now, time.Hour), time.Hour},
+		{"same time", released(now, 3*time.Hour), 2 * time.Hour},
+		{"later", released(now.Add(time.Second), 2*time.Hour), 2 * time.Hour},
+		{"same time again", released(now.Add(time.Second), 6*time.Hour), 3 * time.Hour},
+	}
+	for _, step := range steps {
+		m.events = append(m.events, step.event)
+		got := h.getAverageHolds()[models.ResourceKey("web", "qa")]
+		if got != step.want {
+			t.Errorf("%s: got %s, want %s", step.name, got, step.want)
+		}
+	}
+}
+
+func TestEstimateWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		// users are in line ahead of x, given as their names followed by the hold they asked for, if any
+		users   []string
+		limit   time.Duration
+		average time.Duration
+		want    time.Duration
+		ok      bool
+	}{
+		{"holding", 1, nil, 0, 30 * time.Minute, 0, false},
+		{"nothing to go on", 1, []string{"a"}, 0, 0, 0, false},
+		{"average", 1, []string{"a"}, 0, 30 * time.Minute, 30 * time.Minute, true},
+		{"limit", 1, []string{"a"}, time.Hour, 0, time.Hour, true},
+		{"average within the limit", 1, []string{"a"}, time.Hour, 30 * time.Minute, 30 * time.Minute, true},
+		{"limit below the average", 1, []string{"a"}, 20 * time.Minute, 30 * time.Minute, 20 * time.Minute, true},
+		{"everyone ahead", 1, []string{"a", "b", "c"}, 0, 30 * time.Minute, 90 * time.Minute, true},
+		{"own holds", 1, []string{"a:10m", "b"}, 0, 30 * time.Minute, 40 * time.Minute, true},
+		{"one without an estimate", 1, []string{"a", "b:15m"}, 0, 0, 0, false},
+		{"capacity", 2, []string{"a", "b", "c", "d"}, 0, 30 * time.Minute, time.Hour, true},
+		{"capacity with a short hold", 2, []string{"a:10m", "b", "c"}, 0, 30 * time.Minute, 30 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		m := data.NewMemory()
+		h := &Handler{data: m, limits: Limits{Hold: tt.limit}}
+		if err := m.Create("web", "qa"); err != nil {
+			t.Fatal(err)
+		}
+		capacity := tt.capacity
+		err := m.UpdateResource("web", "qa", func(r *models.Resource) error {
+			r.Capacity = capacity
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range append(tt.users, "x") {
+			parts := strings.SplitN(spec, ":", 2)
+			u := &models.User{ID: parts[0], Name: parts[0]}
+			if err := m.Reserve(u, "web", "qa"); err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) < 2 {
+				continue
+			}
+			hold, err := time.ParseDuration(parts[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.UpdateReservation(u, "web", "qa", func(res *models.Reservation) error {
+				res.Hold = hold
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Holds started moments ago, so a little less than the whole of each is left
+		got, ok := h.estimateWait(&models.User{ID: "x", Name: "x"}, "web", "qa", tt.average)
+		if got.Round(time.Minute) != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ameliagapin/reservebot/data"
@@ -20,20 +21,49 @@ type Handler struct {
 
 	reqEnv bool
 	admins []string
+	// prioritized are the users other than admins who can reserve with a high priority
+	prioritized []string
+	// owners are the users who can reserve on behalf of others in each env, besides admins
+	owners map[string][]string
+	limits Limits
+	// pools maps pool names to the patterns of their members
+	pools map[string]string
+
+	// homes are when each user last opened the App Home. It is refreshed for them in the background whenever
+	// there are events after homeSettled, until they haven't opened it for homeExpiry. Both are guarded by
+	// homeLock.
+	homes       map[string]time.Time
+	homeSettled time.Time
+	homeLock    sync.Mutex
+	// homeRefresh asks the background worker to refresh App Homes
+	homeRefresh chan struct{}
+	settleLock  sync.Mutex
+	// holds are the recent holds that estimates are based on
+	holds holdCache
 }
 
 type EventAction struct {
 	Event  *slackevents.MessageEvent
 	Action string
+	// ResponseURL is where replies go when the event came from a slash command. They are only shown to the user.
+	ResponseURL string
 }
 
-func New(client *slack.Client, data data.Manager, reqEnv bool, admins []string) *Handler {
-	return &Handler{
-		client: client,
-		data:   data,
-		reqEnv: reqEnv,
-		admins: admins,
+func New(client *slack.Client, data data.Manager, reqEnv bool, admins []string, prioritized []string, owners map[string][]string, limits Limits, pools map[string]string) *Handler {
+	h := &Handler{
+		client:      client,
+		data:        data,
+		reqEnv:      reqEnv,
+		admins:      admins,
+		prioritized: prioritized,
+		owners:      owners,
+		limits:      limits,
+		pools:       pools,
+		homes:       map[string]time.Time{},
+		homeRefresh: make(chan struct{}, 1),
 	}
+	go h.refreshHomes()
+	return h
 }
 
 func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
@@ -55,6 +85,10 @@ func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 				SourceTeam:      ev.SourceTeam,
 			},
 		}
+	case *slackevents.AppHomeOpenedEvent:
+		if ev.Tab == "home" {
+			return h.openHome(ev.User)
+		}
 	case *slackevents.MessageEvent:
 		if h.shouldHandle(ev) {
 			ea = &EventAction{
@@ -68,6 +102,12 @@ func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 
 	// Now we determine what to do with it
 	ea.Action = h.getAction(ea.Event.Text)
+	err := h.handle(ea)
+	h.Settle()
+	return err
+}
+
+func (h *Handler) handle(ea *EventAction) error {
 	switch ea.Action {
 	case "hello":
 		return h.sayHello(ea)
@@ -75,6 +115,8 @@ func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 		return h.create(ea)
 	case "reserve", "reserve_dm":
 		return h.reserve(ea)
+	case "reserve_all", "reserve_all_dm":
+		return h.reserveAll(ea)
 	case "release", "release_dm":
 		return h.release(ea)
 	case "removeme", "removeme_dm":
@@ -95,6 +137,48 @@ func (h *Handler) CallbackEvent(event slackevents.EventsAPIEvent) error {
 		return h.singleStatus(ea)
 	case "prune", "prune_dm":
 		return h.prune(ea)
+	case "limit", "limit_dm":
+		return h.limit(ea)
+	case "extend", "extend_dm":
+		return h.extend(ea)
+	case "extensions", "extensions_dm":
+		return h.extensions(ea)
+	case "keep", "keep_dm":
+		return h.keep(ea)
+	case "note", "note_dm":
+		return h.note(ea)
+	case "describe", "describe_dm":
+		return h.describe(ea)
+	case "tag", "tag_dm":
+		return h.tag(ea)
+	case "capacity", "capacity_dm":
+		return h.capacity(ea)
+	case "deadlocks", "deadlocks_dm":
+		return h.deadlocks(ea)
+	case "book", "book_dm", "book_every", "book_every_dm":
+		return h.book(ea)
+	case "calendar", "calendar_dm":
+		return h.calendar(ea)
+	case "unbook", "unbook_dm":
+		return h.unbook(ea)
+	case "handoff", "handoff_dm":
+		return h.handoff(ea)
+	case "move", "move_dm":
+		return h.move(ea)
+	case "swap", "swap_dm":
+		return h.swap(ea)
+	case "all_bookings", "all_bookings_dm", "my_bookings", "my_bookings_dm":
+		return h.bookings(ea)
+	case "pause", "pause_dm", "resume", "resume_dm":
+		return h.pause(ea)
+	case "watch", "watch_dm":
+		return h.watch(ea)
+	case "unwatch", "unwatch_dm":
+		return h.unwatch(ea)
+	case "my_watches", "my_watches_dm":
+		return h.myWatches(ea)
+	case "stats", "stats_dm":
+		return h.statsCmd(ea)
 	case "help", "help_dm":
 		return h.help(ea)
 	default:
@@ -118,7 +202,7 @@ func (h *Handler) sayHello(ea *EventAction) error {
 	u, err := h.getUser(ev.User)
 	if err != nil {
 		log.Errorf("%+v", err)
-		h.errorReply(ev.Channel, "")
+		h.errorReply(ea, "")
 		return err
 	}
 
@@ -134,26 +218,67 @@ func (h *Handler) getCurrentResText(resource *models.Resource, mention bool) (st
 
 	msg := ""
 	queue := []string{}
+	for _, next := range q.Waiting() {
+		queue = append(queue, h.getUserDisplayWithDuration(next, false)+getPriorityText(next))
+	}
 
-	switch len(q.Reservations) {
-	case 0:
+	switch {
+	case len(q.Reservations) == 0:
 		msg = fmt.Sprintf("`%s` is free", resource)
-	case 1:
+	case q.Resource.Holders() > 1:
+		held := []string{}
+		for _, res := range q.Holders() {
+			held = append(held, h.getUserDisplayWithDuration(res, mention)+getPendingText(res)+h.getExpiryText(res)+getNoteText(res))
+		}
+		msg = fmt.Sprintf("`%s` is held by %s (%d/%d)", resource, strings.Join(held, ", "), len(held), q.Resource.Holders())
+		if len(queue) > 0 {
+			msg += ", waiting: " + strings.Join(queue, ", ")
+		}
+	case len(q.Reservations) == 1:
 		user := h.getUserDisplayWithDuration(q.Reservations[0], mention)
-		msg = fmt.Sprintf("`%s` is currently reserved by %s", resource, user)
+		msg = fmt.Sprintf("`%s` is currently reserved by %s%s%s%s", resource, user, getPendingText(q.Reservations[0]), h.getExpiryText(q.Reservations[0]), getNoteText(q.Reservations[0]))
 	default:
 		verb := "is"
-		for _, next := range q.Reservations[1:] {
-			queue = append(queue, h.getUserDisplayWithDuration(next, false))
-		}
 		if len(queue) > 1 {
 			verb = "are"
 		}
 		user := h.getUserDisplayWithDuration(q.Reservations[0], mention)
-		msg = fmt.Sprintf("`%s` is currently reserved by %s. %s %s waiting.", resource, user, strings.Join(queue, ", "), verb)
+		msg = fmt.Sprintf("`%s` is currently reserved by %s%s%s%s. %s %s waiting.", resource, user, getPendingText(q.Reservations[0]), h.getExpiryText(q.Reservations[0]), getNoteText(q.Reservations[0]), strings.Join(queue, ", "), verb)
+	}
+
+	return msg + getMetadataText(q.Resource) + h.getRecurringText(q.Resource), nil
+}
+
+// isHolder returns whether the user at pos in a resource's queue holds the resource
+func (h *Handler) isHolder(name, env string, pos int) bool {
+	r := h.data.GetResource(name, env, false)
+	return r != nil && pos >= 1 && pos <= r.Holders()
+}
+
+// getHeldReservation returns the user's reservation of a resource if they hold it. A place set aside for a
+// pending composite request is not held yet.
+func (h *Handler) getHeldReservation(u *models.User, name, env string) *models.Reservation {
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil {
+		return nil
 	}
+	for _, res := range q.Holders() {
+		if res.User.ID == u.ID && !res.Pending() {
+			return res
+		}
+	}
+	return nil
+}
 
-	return msg, nil
+// getNextInLine returns the reservation of the first user waiting for a resource. They become a holder as
+// soon as a holder leaves. If their place is only set aside for a pending composite request, there is nobody to
+// hand the resource to yet.
+func (h *Handler) getNextInLine(name, env string) *models.Reservation {
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil || len(q.Waiting()) == 0 || q.Waiting()[0].Pending() {
+		return nil
+	}
+	return q.Waiting()[0]
 }
 
 func (h *Handler) getUserDisplay(user *models.User, mention bool) string {
@@ -176,15 +301,19 @@ func (h *Handler) getUserDisplayWithDuration(reservation *models.Reservation, me
 }
 
 func getDuration(t time.Time) string {
-	duration := time.Since(t).Round(time.Minute)
+	return formatDuration(time.Since(t))
+}
+
+func formatDuration(d time.Duration) string {
+	duration := d.Round(time.Minute)
 
 	if duration < 1 {
 		return "0m"
 	}
 
-	d := duration.String()
+	str := duration.String()
 
-	return d[:len(d)-2]
+	return str[:len(str)-2]
 }
 
 // getMatches retrieves all capture group values from a given text for regex action
@@ -257,17 +386,37 @@ func (h *Handler) handleGetResourceError(ea *EventAction, err error) {
 	if err == e.InvalidResourceFormat {
 		msg = msgResourceImproperlyFormatted
 	}
-	h.errorReply(ea.Event.Channel, msg)
+	h.errorReply(ea, msg)
 }
 
-func (h *Handler) errorReply(channel, msg string) {
+func (h *Handler) errorReply(ea *EventAction, msg string) {
 	if msg == "" {
 		msg = msgIDontKnow
 	}
-	h.client.PostMessage(channel, slack.MsgOptionText(msg, false))
+	h.post(ea, msg)
+}
+
+// post sends a message to the channel of the event, or only to the user when the event came from a slash command
+// or a button. The message is shown as blocks if there are any, with msg as the fallback.
+func (h *Handler) post(ea *EventAction, msg string, blocks ...slack.Block) error {
+	opts := []slack.MsgOption{slack.MsgOptionText(msg, false)}
+	if len(blocks) > 0 {
+		opts = append(opts, slack.MsgOptionBlocks(blocks...))
+	}
+	if ea.ResponseURL != "" {
+		opts = append(opts, slack.MsgOptionResponseURL(ea.ResponseURL, slack.ResponseTypeEphemeral))
+	}
+	_, _, err := h.client.PostMessage(ea.Event.Channel, opts...)
+	return err
 }
 
 func (h *Handler) reply(ea *EventAction, msg string, address bool) error {
+	return h.replyWithBlocks(ea, msg, address, nil)
+}
+
+// replyWithBlocks replies like reply, but shows the blocks instead of the text. If there are more blocks than a
+// message can hold, the rest follow in further messages.
+func (h *Handler) replyWithBlocks(ea *EventAction, msg string, address bool, blocks []slack.Block) error {
 	// If message is in DM or does not start with addressing a user, capitalize the first letter
 	if !address || ea.Event.ChannelType == "im" {
 		msg = fmt.Sprintf("%s%s", strings.ToUpper(msg[:1]), msg[1:])
@@ -283,8 +432,20 @@ func (h *Handler) reply(ea *EventAction, msg string, address bool) error {
 		}
 	}
 
-	_, _, err := h.client.PostMessage(ea.Event.Channel, slack.MsgOptionText(msg, false))
-	return err
+	for len(blocks) > maxBlocks {
+		// A resource's buttons stay in the same message as its text
+		n := maxBlocks
+		if _, ok := blocks[n].(*slack.ActionBlock); ok {
+			n--
+		}
+		err := h.post(ea, msg, blocks[:n]...)
+		if err != nil {
+			return err
+		}
+		blocks = blocks[n:]
+		msg = msgContinued
+	}
+	return h.post(ea, msg, blocks...)
 }
 
 func (h *Handler) announce(ea *EventAction, user *models.User, msg string) error {
@@ -296,12 +457,18 @@ func (h *Handler) announce(ea *EventAction, user *models.User, msg string) error
 	return err
 }
 
-func (h *Handler) sendDM(user *models.User, msg string) error {
+// sendDM sends a message to the user directly. The message is shown as blocks if there are any, with msg as the
+// fallback.
+func (h *Handler) sendDM(user *models.User, msg string, blocks ...slack.Block) error {
 	_, _, c, err := h.client.OpenIMChannel(user.ID)
 	if err != nil {
 		return err
 	}
-	_, _, err = h.client.PostMessage(c, slack.MsgOptionText(msg, false))
+	opts := []slack.MsgOption{slack.MsgOptionText(msg, false)}
+	if len(blocks) > 0 {
+		opts = append(opts, slack.MsgOptionBlocks(blocks...))
+	}
+	_, _, err = h.client.PostMessage(c, opts...)
 	return err
 }
 
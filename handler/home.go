@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// maxHomeBlocks is the most blocks Slack allows in an App Home view
+const maxHomeBlocks = 100
+
+// homeDebounce is how long the background worker waits after being asked to refresh App Homes, so that a
+// burst of changes only refreshes them once
+const homeDebounce = 2 * time.Second
+
+// homeExpiry is how long after a user last opened the App Home it is no longer refreshed. Slack tells us every
+// time it is opened, so it is refreshed again from then on.
+const homeExpiry = 24 * time.Hour
+
+// openHome publishes the App Home of a user who opened it. From then on, it is refreshed whenever
+// reservations change.
+func (h *Handler) openHome(userID string) error {
+	h.homeLock.Lock()
+	// Homes are not refreshed while nobody has one, so there is nothing to catch up on
+	if len(h.homes) == 0 {
+		h.homeSettled = h.getLastEventTime(h.homeSettled)
+	}
+	h.homes[userID] = time.Now()
+	h.homeLock.Unlock()
+
+	return h.publishHome(userID, h.getAverageHolds())
+}
+
+// settleHomes asks the background worker to refresh App Homes. It does not wait for it.
+func (h *Handler) settleHomes() {
+	select {
+	case h.homeRefresh <- struct{}{}:
+	default:
+		// A refresh is already pending
+	}
+}
+
+// refreshHomes is the background worker that refreshes App Homes whenever settleHomes asks it to
+func (h *Handler) refreshHomes() {
+	for range h.homeRefresh {
+		time.Sleep(homeDebounce)
+		h.publishHomes()
+	}
+}
+
+// publishHomes refreshes the App Home of everyone who has opened it recently if there have been any changes
+// since the last time. Everyone else is forgotten.
+func (h *Handler) publishHomes() {
+	h.homeLock.Lock()
+	now := time.Now()
+	ids := []string{}
+	for id, opened := range h.homes {
+		if now.Sub(opened) > homeExpiry {
+			delete(h.homes, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		h.homeLock.Unlock()
+		return
+	}
+
+	latest := h.getLastEventTime(h.homeSettled)
+	if !latest.After(h.homeSettled) {
+		h.homeLock.Unlock()
+		return
+	}
+	h.homeSettled = latest
+	h.homeLock.Unlock()
+
+	averages := h.getAverageHolds()
+	for _, id := range ids {
+		err := h.publishHome(id, averages)
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
+
+// getLastEventTime returns the time of the last event that changed reservations since the given time, or that
+// time if there were none
+func (h *Handler) getLastEventTime(since time.Time) time.Time {
+	latest := since
+	for _, ev := range h.data.GetEvents(since) {
+		if ev.Time.After(latest) {
+			latest = ev.Time
+		}
+	}
+	return latest
+}
+
+// publishHome publishes a user's App Home. averages are the average holds from getAverageHolds.
+func (h *Handler) publishHome(userID string, averages map[string]time.Duration) error {
+	u, err := h.getUser(userID)
+	if err != nil {
+		return err
+	}
+	view := slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: h.getHomeBlocks(u, averages)},
+	}
+	_, err = h.client.PublishView(userID, view, "")
+	return err
+}
+
+// getHomeBlocks returns the blocks of a user's App Home: what they hold and are waiting for, followed by every
+// resource grouped by env. If there are too many resources for buttons, they are left out, and if there are
+// still too many, the rest are cut off.
+func (h *Handler) getHomeBlocks(u *models.User, averages map[string]time.Duration) []slack.Block {
+	section := func(text string) slack.Block {
+		return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)
+	}
+
+	resources := h.data.GetResources()
+
+	blocks := []slack.Block{section(msgHomeYourReservations)}
+	mine := 0
+	for _, r := range resources {
+		if h.data.GetReservation(u, r.Name, r.Env) == nil {
+			continue
+		}
+		mine++
+
+		var text string
+		if held := h.getHeldReservation(u, r.Name, r.Env); held != nil {
+			text = fmt.Sprintf(msgHomeHeldYForX, r, formatDuration(time.Since(held.Time)), h.getExpiryText(held))
+		} else {
+			pos, _ := h.data.GetPosition(u, r.Name, r.Env)
+			text = fmt.Sprintf(msgHomeNInLineForY, util.Ordinalize(pos), r, h.getEstimateText(u, r, averages))
+		}
+		blocks = append(blocks, h.getResourceBlocks(u, r, text)...)
+	}
+	if mine == 0 {
+		blocks = append(blocks, section(msgYouHaveNoReservations))
+	}
+
+	envs := map[string][]*models.Resource{}
+	names := []string{}
+	for _, r := range resources {
+		if _, ok := envs[r.Env]; !ok {
+			names = append(names, r.Env)
+		}
+		envs[r.Env] = append(envs[r.Env], r)
+	}
+	sort.Strings(names)
+
+	board := func(buttons bool) []slack.Block {
+		ret := []slack.Block{slack.NewDividerBlock(), section(msgHomeAllResources)}
+		for _, env := range names {
+			name := env
+			if name == "" {
+				name = "global"
+			}
+			ret = append(ret, section(fmt.Sprintf(msgHomeEnvX, name)))
+			for _, r := range envs[env] {
+				text, err := h.getCurrentResText(r, false)
+				if err != nil {
+					log.Errorf("%+v", err)
+					continue
+				}
+				if buttons {
+					ret = append(ret, h.getResourceBlocks(u, r, text)...)
+				} else {
+					ret = append(ret, section(text))
+				}
+			}
+		}
+		return ret
+	}
+	if len(resources) == 0 {
+		return append(blocks, slack.NewDividerBlock(), section(msgNoReservations))
+	}
+
+	all := append(blocks, board(true)...)
+	if len(all) <= maxHomeBlocks {
+		return all
+	}
+	all = append(blocks, board(false)...)
+	if len(all) <= maxHomeBlocks {
+		return all
+	}
+	return append(all[:maxHomeBlocks-1], section(msgHomeTooManyResources))
+}
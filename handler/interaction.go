@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// maxBlocks is the most blocks Slack allows in a message
+const maxBlocks = 50
+
+// Action IDs of the buttons in status messages. The value of each button is the resource it acts on.
+const (
+	actionRelease = "release"
+	actionJoin    = "join"
+	actionLeave   = "leave"
+	actionExtend  = "extend"
+)
+
+// buttonCommands maps each button to the command it runs on its resource
+var buttonCommands = map[string]string{
+	actionRelease: "release %s",
+	actionJoin:    "reserve %s",
+	actionLeave:   "remove me from %s",
+	actionExtend:  "extend %s",
+}
+
+// getResourceBlocks returns the blocks that show a resource's status text, followed by buttons for what the user
+// can do with it: release or extend it if they hold it, leave the queue if they are waiting, or join it otherwise.
+// If u is nil, the message is seen by everyone in a channel, so the buttons are the same for everyone. Buttons
+// run as whoever presses them, so anyone they don't apply to is told so.
+func (h *Handler) getResourceBlocks(u *models.User, r *models.Resource, text string) []slack.Block {
+	buttons := []slack.BlockElement{}
+	button := func(action, label string) {
+		b := slack.NewButtonBlockElement(action, r.String(), slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+		buttons = append(buttons, b)
+	}
+
+	if u == nil {
+		button(actionJoin, "Join queue")
+		button(actionLeave, "Leave queue")
+		if cu, err := h.data.GetReservationForResource(r.Name, r.Env); err == nil && cu != nil {
+			button(actionRelease, "Release")
+		}
+		return []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewActionBlock("", buttons...),
+		}
+	}
+
+	cu := h.getHeldReservation(u, r.Name, r.Env)
+	switch {
+	case cu != nil:
+		button(actionRelease, "Release")
+		if h.holdLimit(cu) > 0 {
+			button(actionExtend, "Extend")
+		}
+	case h.data.GetReservation(u, r.Name, r.Env) != nil:
+		button(actionLeave, "Leave queue")
+	default:
+		button(actionJoin, "Join queue")
+	}
+
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("", buttons...),
+	}
+}
+
+// getButtonUser returns the user whose buttons are shown in a reply to ea, or nil if the reply can be seen by
+// others and must show buttons that work for everyone
+func getButtonUser(ea *EventAction, u *models.User) *models.User {
+	if ea.Event.ChannelType == "im" || ea.ResponseURL != "" {
+		return u
+	}
+	return nil
+}
+
+// Interaction runs the commands of the buttons a user pressed, as if they had sent them in a DM. Replies are
+// only shown to the user.
+func (h *Handler) Interaction(cb slack.InteractionCallback) error {
+	if cb.Type != slack.InteractionTypeBlockActions {
+		return nil
+	}
+
+	// Buttons in the App Home have no message to reply to, so the replies go to a DM
+	channel := cb.Channel.ID
+	if cb.View.Type == slack.VTHomeTab {
+		_, _, c, err := h.client.OpenIMChannel(cb.User.ID)
+		if err != nil {
+			return err
+		}
+		channel = c
+	}
+
+	for _, action := range cb.ActionCallback.BlockActions {
+		cmd, ok := buttonCommands[action.ActionID]
+		if !ok {
+			log.Errorf("Unknown action %s", action.ActionID)
+			continue
+		}
+		err := h.handlePrivate(cb.User.ID, channel, cb.ResponseURL, fmt.Sprintf(cmd, action.Value))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+	h.Settle()
+	return nil
+}
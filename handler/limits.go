@@ -0,0 +1,414 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Limits configures how long resources may be held
+type Limits struct {
+	// Hold is the global hold limit. Zero means there is no limit.
+	Hold time.Duration
+	// EnvHold overrides Hold for specific envs
+	EnvHold map[string]time.Duration
+	// Remind is how long before a hold expires its holder is reminded. Zero disables reminders.
+	Remind time.Duration
+	// Nudge is how long a resource can be held while others are waiting before its holder is asked whether
+	// they still need it. Zero disables nudges.
+	Nudge time.Duration
+}
+
+// errUnchanged is returned by an update that finds the record no longer needs the change, so that the store
+// leaves it as it is
+var errUnchanged = errors.New("UNCHANGED")
+
+var holdRegex = regexp.MustCompile(`^(.+?)\s+for\s+(\S+)$`)
+
+// parseHold splits an optional "for <duration>" suffix from a resource list
+func parseHold(text string) (string, time.Duration, error) {
+	matches := holdRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return text, 0, nil
+	}
+	d, err := time.ParseDuration(matches[2])
+	if err != nil || d <= 0 {
+		return text, 0, e.InvalidDuration
+	}
+	return matches[1], d, nil
+}
+
+// holdLimit returns how long a reservation may be held. In order of precedence, the limit is the one
+// requested for the reservation, the resource's limit, the env's limit and finally the global limit.
+// Zero means there is no limit.
+func (h *Handler) holdLimit(res *models.Reservation) time.Duration {
+	if res.Hold > 0 {
+		return res.Hold
+	}
+	if res.Resource.HoldLimit > 0 {
+		return res.Resource.HoldLimit
+	}
+	if d, ok := h.limits.EnvHold[res.Resource.Env]; ok {
+		return d
+	}
+	return h.limits.Hold
+}
+
+// getExpiryText returns the text describing when the holder's reservation expires, if it does
+func (h *Handler) getExpiryText(res *models.Reservation) string {
+	expires := res.Expires(h.holdLimit(res))
+	if expires.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(msgCommaExpiresInX, formatDuration(time.Until(expires)))
+}
+
+// ReleaseExpired releases every resource that has been held for longer than its hold limit. The previous
+// holder and the new holder are notified via DM.
+func (h *Handler) ReleaseExpired() {
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		for _, holder := range q.Holders() {
+			expires := holder.Expires(h.holdLimit(holder))
+			if expires.IsZero() || now.Before(expires) {
+				continue
+			}
+
+			res := q.Resource
+			cu := h.getNextInLine(res.Name, res.Env)
+			err := h.watchQueue(res.Name, res.Env, func() error {
+				return h.data.Remove(holder.User, res.Name, res.Env)
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			log.Infof("Reservation of %s by %s expired", res, holder.User.Name)
+
+			err = h.sendDM(holder.User, fmt.Sprintf(msgYourHoldOnYExpired, res))
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+
+			if cu != nil {
+				err = h.sendDM(cu.User, fmt.Sprintf(msgXsHoldOnYExpiredItIsYours, h.getUserDisplay(holder.User, false), res))
+				if err != nil {
+					log.Errorf("%+v", err)
+				}
+			}
+		}
+	}
+}
+
+// RemindExpiring sends a DM to every holder whose hold expires within the reminder window, offering to
+// extend it. Each hold is reminded once, and again after every extension.
+func (h *Handler) RemindExpiring() {
+	if h.limits.Remind <= 0 {
+		return
+	}
+	now := time.Now()
+
+	for _, q := range h.data.GetQueues() {
+		for _, holder := range q.Holders() {
+			expires := holder.Expires(h.holdLimit(holder))
+			if holder.Reminded || expires.IsZero() || expires.Sub(now) > h.limits.Remind {
+				continue
+			}
+
+			err := h.data.UpdateReservation(holder.User, q.Resource.Name, q.Resource.Env, func(res *models.Reservation) error {
+				// The hold may have been extended or handed over since the queue was read
+				if res.Reminded || !res.Expires(h.holdLimit(res)).Equal(expires) {
+					return errUnchanged
+				}
+				res.Reminded = true
+				return nil
+			})
+			if err == errUnchanged {
+				continue
+			}
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+
+			res := q.Resource
+			msg := fmt.Sprintf(msgYourHoldOnYExpiresInZ, res, formatDuration(time.Until(expires)))
+			if max := res.MaxExtensions; max != nil && holder.Extensions >= *max {
+				msg += msgPeriodItCannotBeExtended
+			} else {
+				msg += fmt.Sprintf(msgPeriodReplyExtendY, res)
+			}
+			// The DM comes with the holder's buttons, so they can extend it right there
+			err = h.sendDM(holder.User, msg, h.getResourceBlocks(holder.User, res, msg)...)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+}
+
+func (h *Handler) extend(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	// Without a duration, the hold is extended by its limit
+	by := time.Duration(0)
+	if matches[1] != "" {
+		by, err = time.ParseDuration(matches[1])
+		if err != nil || by <= 0 {
+			h.errorReply(ea, msgInvalidDuration)
+			return nil
+		}
+	}
+
+	for _, res := range resources {
+		cu := h.getHeldReservation(u, res.Name, res.Env)
+		if cu == nil {
+			h.errorReply(ea, fmt.Sprintf(msgYouDoNotHaveY, res))
+			continue
+		}
+
+		limit := h.holdLimit(cu)
+		if limit <= 0 {
+			h.reply(ea, fmt.Sprintf(msgYDoesNotExpire, res), false)
+			continue
+		}
+
+		d := by
+		if d == 0 {
+			d = limit
+		}
+		extended := *cu
+		err = h.data.UpdateReservation(u, res.Name, res.Env, func(held *models.Reservation) error {
+			if max := held.Resource.MaxExtensions; max != nil && held.Extensions >= *max {
+				return errUnchanged
+			}
+			held.Extensions++
+			held.Extended += d
+			held.Reminded = false
+			extended = *held
+			return nil
+		})
+		if err == errUnchanged {
+			h.errorReply(ea, fmt.Sprintf(msgYCannotBeExtendedAgain, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgYouHaveExtendedYZ, res, h.getExpiryText(&extended)), false)
+	}
+
+	return nil
+}
+
+// extensions sets how many times holds on a resource can be extended
+func (h *Handler) extensions(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `extensions`.", false)
+		return nil
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	var max *int
+	if matches[1] != "off" {
+		n, err := strconv.Atoi(matches[1])
+		if err != nil || n < 0 {
+			h.errorReply(ea, msgInvalidNumber)
+			return nil
+		}
+		max = &n
+	}
+
+	for _, res := range resources {
+		err := h.data.UpdateResource(res.Name, res.Env, func(r *models.Resource) error {
+			r.MaxExtensions = max
+			return nil
+		})
+		if err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		msg := fmt.Sprintf(msgExtensionsForYUnlimited, res)
+		if max != nil {
+			msg = fmt.Sprintf(msgExtensionsForYCappedAtN, res, *max)
+		}
+		h.reply(ea, msg, false)
+	}
+
+	return nil
+}
+
+// capacity sets how many users can hold a resource at once. Anyone who becomes a holder because the
+// capacity was raised is notified via DM.
+func (h *Handler) capacity(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `capacity`.", false)
+		return nil
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	n, err := strconv.Atoi(matches[1])
+	if err != nil || n < 1 {
+		h.errorReply(ea, msgInvalidNumber)
+		return nil
+	}
+
+	// Bookings are checked against the capacity under the same lock
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	for _, res := range resources {
+		q, err := h.data.GetQueueForResource(res.Name, res.Env)
+		if err != nil {
+			if err == e.ResourceDoesNotExist {
+				h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+				continue
+			}
+			h.errorReply(ea, err.Error())
+			continue
+		}
+		waiting := map[string]bool{}
+		for _, res := range q.Waiting() {
+			waiting[res.User.ID] = true
+		}
+
+		err = h.watchQueue(res.Name, res.Env, func() error {
+			return h.data.UpdateResource(res.Name, res.Env, func(r *models.Resource) error {
+				r.Capacity = n
+				return nil
+			})
+		})
+		if err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgCapacityOfYIsN, res, n), false)
+
+		q, err = h.data.GetQueueForResource(res.Name, res.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		for _, holder := range q.Holders() {
+			if waiting[holder.User.ID] && !holder.Pending() {
+				h.announce(ea, holder.User, fmt.Sprintf(msgXRaisedCapacityOfYItIsYours, h.getUserDisplay(u, false), res))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) limit(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `limit`.", false)
+		return nil
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	limit := time.Duration(0)
+	if matches[1] != "off" {
+		limit, err = time.ParseDuration(matches[1])
+		if err != nil || limit <= 0 {
+			h.errorReply(ea, msgInvalidDuration)
+			return nil
+		}
+	}
+
+	for _, res := range resources {
+		err := h.data.UpdateResource(res.Name, res.Env, func(r *models.Resource) error {
+			r.HoldLimit = limit
+			return nil
+		})
+		if err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		msg := fmt.Sprintf(msgHoldLimitForYRemoved, res)
+		if limit > 0 {
+			msg = fmt.Sprintf(msgHoldLimitForYIsZ, res, formatDuration(limit))
+		}
+		h.reply(ea, msg, false)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+)
+
+func TestParseHold(t *testing.T) {
+	tests := []struct {
+		text string
+		rest string
+		hold time.Duration
+		err  error
+	}{
+		{"qa|web", "qa|web", 0, nil},
+		{"qa|web for 2h", "qa|web", 2 * time.Hour, nil},
+		{"qa|web for 1h30m", "qa|web", 90 * time.Minute, nil},
+		{"qa|web, qa|api  for  45m", "qa|web, qa|api", 45 * time.Minute, nil},
+		{"qa|web for 2h extra", "qa|web for 2h extra", 0, nil},
+		{"for 2h", "for 2h", 0, nil},
+		{"qa|web for a while", "qa|web for a while", 0, nil},
+		{"qa|web for soon", "qa|web for soon", 0, e.InvalidDuration},
+		{"qa|web for 0s", "qa|web for 0s", 0, e.InvalidDuration},
+		{"qa|web for -1h", "qa|web for -1h", 0, e.InvalidDuration},
+	}
+	for _, tt := range tests {
+		rest, hold, err := parseHold(tt.text)
+		if rest != tt.rest || hold != tt.hold || err != tt.err {
+			t.Errorf("%q: got %q, %s, %v, want %q, %s, %v", tt.text, rest, hold, err, tt.rest, tt.hold, tt.err)
+		}
+	}
+}
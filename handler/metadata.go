@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+var (
+	// Slack sends links as <url> or <url|label>
+	linkRegex = regexp.MustCompile(`<(https?://[^|>]+)(?:\|[^>]*)?>`)
+	teamRegex = regexp.MustCompile(`(?:^|\s)team:(\S+)`)
+)
+
+// getMetadataText returns the description, team, tags and links of a resource as an indented line, if it
+// has any
+func getMetadataText(r *models.Resource) string {
+	parts := []string{}
+	if r.Description != "" {
+		parts = append(parts, r.Description)
+	}
+	if r.Team != "" {
+		parts = append(parts, fmt.Sprintf("team: *%s*", r.Team))
+	}
+	if len(r.Tags) > 0 {
+		tags := []string{}
+		for _, t := range r.Tags {
+			tags = append(tags, TICK+t+TICK)
+		}
+		parts = append(parts, "tags: "+strings.Join(tags, " "))
+	}
+	parts = append(parts, r.Links...)
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\n>" + strings.Join(parts, " · ")
+}
+
+// describe sets the metadata of a resource. A team is given as team:<name> and any links replace the
+// current ones. Whatever text is left replaces the description.
+func (h *Handler) describe(ea *EventAction) error {
+	ev := ea.Event
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	text := strings.TrimSpace(matches[1])
+	clear := text == "clear"
+
+	links := []string{}
+	for _, m := range linkRegex.FindAllStringSubmatch(text, -1) {
+		links = append(links, m[1])
+	}
+	text = linkRegex.ReplaceAllString(text, "")
+
+	team := ""
+	if m := teamRegex.FindStringSubmatch(text); m != nil {
+		team = m[1]
+	}
+	text = teamRegex.ReplaceAllString(text, "")
+	description := strings.Join(strings.Fields(text), " ")
+
+	for _, res := range resources {
+		described := models.Resource{}
+		err := h.data.UpdateResource(res.Name, res.Env, func(r *models.Resource) error {
+			if clear {
+				r.Description = ""
+				r.Team = ""
+				r.Links = nil
+			} else {
+				if description != "" {
+					r.Description = description
+				}
+				if team != "" {
+					r.Team = team
+				}
+				if len(links) > 0 {
+					r.Links = links
+				}
+			}
+			described = *r
+			return nil
+		})
+		if err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgYHasBeenDescribed, res)+getMetadataText(&described), false)
+	}
+
+	return nil
+}
+
+// tag adds the tags prefixed with + to a resource and removes those prefixed with -
+func (h *Handler) tag(ea *EventAction) error {
+	ev := ea.Event
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	add := []string{}
+	remove := []string{}
+	for _, f := range strings.Fields(matches[1]) {
+		t := strings.ToLower(strings.TrimLeft(f, "+-"))
+		if t == "" {
+			continue
+		}
+		if strings.HasPrefix(f, "-") {
+			remove = append(remove, t)
+		} else {
+			add = append(add, t)
+		}
+	}
+
+	for _, res := range resources {
+		tagged := models.Resource{}
+		err := h.data.UpdateResource(res.Name, res.Env, func(r *models.Resource) error {
+			tags := []string{}
+			for _, t := range r.Tags {
+				if !util.InSlice(remove, t) {
+					tags = append(tags, t)
+				}
+			}
+			for _, t := range add {
+				if !util.InSlice(tags, t) {
+					tags = append(tags, t)
+				}
+			}
+			r.Tags = tags
+			tagged = *r
+			return nil
+		})
+		if err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgYHasBeenTagged, res)+getMetadataText(&tagged), false)
+	}
+
+	return nil
+}
+
+// tagStatus provides the status of every resource with a tag
+func (h *Handler) tagStatus(ea *EventAction, tag string) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+	tag = strings.ToLower(tag)
+
+	resp := ""
+	blocks := []slack.Block{}
+	for _, res := range h.data.GetResources() {
+		if !res.HasTag(tag) {
+			continue
+		}
+		msg, err := h.getCurrentResText(res, false)
+		if err != nil {
+			if err == e.ResourceDoesNotExist {
+				continue
+			}
+			log.Errorf("%+v", err)
+			h.errorReply(ea, "")
+			continue
+		}
+		resp += msg + "\n"
+		blocks = append(blocks, h.getResourceBlocks(getButtonUser(ea, u), res, msg)...)
+	}
+
+	if resp == "" {
+		resp = fmt.Sprintf(msgNoResourcesTaggedX, tag)
+	}
+	h.replyWithBlocks(ea, resp, false, blocks)
+
+	return nil
+}
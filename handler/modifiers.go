@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+// commaRegex matches a comma in a resource list along with any space around it
+var commaRegex = regexp.MustCompile(`\s*,\s*`)
+
+// modifiers are the optional parts of a reserve command that follow its resource list
+type modifiers struct {
+	Note     string
+	Behalf   string
+	Priority models.Priority
+	Hold     time.Duration
+}
+
+// parseModifiers splits the modifiers from a resource list. A note comes last. Before it, "for <@user>",
+// "priority <level>" and "for <duration>" can each be given once, in any order. If there are any other words
+// after the list, the error is e.UnexpectedWords and the words are returned in place of the list.
+func parseModifiers(text string) (string, *modifiers, error) {
+	list, note := parseNote(text)
+	m := &modifiers{Note: note, Priority: models.PriorityNormal}
+
+	prioritized := false
+	for {
+		if rest, behalf := parseBehalf(list); behalf != "" {
+			if m.Behalf != "" {
+				break
+			}
+			list, m.Behalf = rest, behalf
+			continue
+		}
+
+		rest, priority, err := parsePriority(list)
+		if err != nil {
+			return list, nil, err
+		}
+		if rest != list {
+			if prioritized {
+				break
+			}
+			list, m.Priority, prioritized = rest, priority, true
+			continue
+		}
+
+		rest, hold, err := parseHold(list)
+		if err != nil {
+			return list, nil, err
+		}
+		if rest != list && m.Hold == 0 {
+			list, m.Hold = rest, hold
+			continue
+		}
+		break
+	}
+
+	// Resources are separated by commas and a pool pattern follows "any", so any other space starts a word
+	// that is not part of the list
+	words := strings.Fields(commaRegex.ReplaceAllString(strings.TrimPrefix(strings.TrimSpace(list), "any "), ","))
+	if len(words) > 1 {
+		return strings.Join(words[1:], " "), nil, e.UnexpectedWords
+	}
+	return list, m, nil
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+func TestParseModifiers(t *testing.T) {
+	tests := []struct {
+		text string
+		list string
+		want modifiers
+		err  error
+	}{
+		{"qa|web", "qa|web", modifiers{Priority: models.PriorityNormal}, nil},
+		{"qa|web for 2h", "qa|web", modifiers{Priority: models.PriorityNormal, Hold: 2 * time.Hour}, nil},
+		{"qa|web priority high", "qa|web", modifiers{Priority: models.PriorityHigh}, nil},
+		{"qa|web -- testing the deploy", "qa|web", modifiers{Priority: models.PriorityNormal, Note: "testing the deploy"}, nil},
+		{"qa|web priority low for 90m -- soak test", "qa|web", modifiers{Priority: models.PriorityLow, Hold: 90 * time.Minute, Note: "soak test"}, nil},
+		{"qa|web for 90m priority low", "qa|web", modifiers{Priority: models.PriorityLow, Hold: 90 * time.Minute}, nil},
+		{"qa|web for <@U123> for 1h", "qa|web", modifiers{Priority: models.PriorityNormal, Behalf: "U123", Hold: time.Hour}, nil},
+		{"qa|web for 1h for <@U123>", "qa|web", modifiers{Priority: models.PriorityNormal, Behalf: "U123", Hold: time.Hour}, nil},
+		{"qa|web , qa|api for 1h", "qa|web , qa|api", modifiers{Priority: models.PriorityNormal, Hold: time.Hour}, nil},
+		{"any qa|web* for 1h", "any qa|web*", modifiers{Priority: models.PriorityNormal, Hold: time.Hour}, nil},
+		{"qa|web please", "please", modifiers{}, e.UnexpectedWords},
+		{"qa|web for 1h for 2h", "for 1h", modifiers{}, e.UnexpectedWords},
+		{"qa|web priority high priority low", "priority high", modifiers{}, e.UnexpectedWords},
+		{"qa|web for soon", "qa|web for soon", modifiers{}, e.InvalidDuration},
+		{"qa|web priority urgent", "qa|web priority urgent", modifiers{}, e.InvalidPriority},
+	}
+	for _, tt := range tests {
+		list, m, err := parseModifiers(tt.text)
+		if err != tt.err {
+			t.Errorf("%q: got error %v, want %v", tt.text, err, tt.err)
+			continue
+		}
+		if list != tt.list {
+			t.Errorf("%q: got list %q, want %q", tt.text, list, tt.list)
+		}
+		if err == nil && *m != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.text, *m, tt.want)
+		}
+	}
+}
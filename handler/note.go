@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+var noteRegex = regexp.MustCompile(`^(.+?)\s+--\s+(.+)$`)
+
+// parseNote splits an optional "-- <note>" suffix from a resource list
+func parseNote(text string) (string, string) {
+	matches := noteRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return text, ""
+	}
+	return matches[1], strings.TrimSpace(matches[2])
+}
+
+// getNoteText returns the text describing why a reservation was made, if the user said why
+func getNoteText(res *models.Reservation) string {
+	if res.Note == "" {
+		return ""
+	}
+	return fmt.Sprintf(msgColonNoteX, res.Note)
+}
+
+func (h *Handler) note(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+	note := strings.TrimSpace(matches[1])
+
+	for _, res := range resources {
+		err := h.data.UpdateReservation(u, res.Name, res.Env, func(r *models.Reservation) error {
+			r.Note = note
+			return nil
+		})
+		if err == e.NotInQueue || err == e.ResourceDoesNotExist {
+			h.errorReply(ea, fmt.Sprintf(msgYouAreNotInLineForY, res))
+			continue
+		}
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		msg := fmt.Sprintf(msgNoteForYCleared, res)
+		if note != "" {
+			msg = fmt.Sprintf(msgNoteForYIsZ, res, note)
+		}
+		h.reply(ea, msg, false)
+	}
+
+	return nil
+}
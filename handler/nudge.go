@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxNudgeBackoff caps how far apart repeated nudges can get, as a multiple of the nudge threshold
+const maxNudgeBackoff = 8
+
+// nextNudge returns when the holder of a reservation should next be nudged. The first nudge is sent once the
+// resource has been held for the threshold, and the wait doubles after every nudge.
+func nextNudge(res *models.Reservation, threshold time.Duration) time.Time {
+	if res.Nudges == 0 {
+		return res.Time.Add(threshold)
+	}
+	backoff := 1 << uint(res.Nudges)
+	if backoff > maxNudgeBackoff {
+		backoff = maxNudgeBackoff
+	}
+	return res.Nudged.Add(threshold * time.Duration(backoff))
+}
+
+// NudgeIdleHolders sends a DM to every holder who has had a resource for longer than the nudge threshold
+// while others are waiting for it, asking whether they are still using it
+func (h *Handler) NudgeIdleHolders() {
+	if h.limits.Nudge <= 0 {
+		return
+	}
+	now := time.Now()
+
+	for _, r := range h.data.GetResources() {
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		waiting := len(q.Waiting())
+		if waiting < 1 {
+			continue
+		}
+
+		for _, holder := range q.Holders() {
+			// Nobody has been handed a place set aside for a pending composite request yet
+			if holder.Pending() || now.Before(nextNudge(holder, h.limits.Nudge)) {
+				continue
+			}
+
+			err = h.data.UpdateReservation(holder.User, r.Name, r.Env, func(res *models.Reservation) error {
+				// The holder may have said they are keeping it since the queue was read
+				if now.Before(nextNudge(res, h.limits.Nudge)) {
+					return errUnchanged
+				}
+				res.Nudges++
+				res.Nudged = now
+				return nil
+			})
+			if err == errUnchanged {
+				continue
+			}
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+
+			err = h.sendDM(holder.User, fmt.Sprintf(msgNWaitingForYForZStillUsingIt, waiting, r, getDuration(holder.Time), r))
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}
+	}
+}
+
+// keep lets a nudged holder say they are still using a resource. The next nudge is pushed back as if
+// they had just been nudged.
+func (h *Handler) keep(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	resources, err := h.getResourcesFromCommaList(matches[0])
+	if err != nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	for _, res := range resources {
+		cu := h.getHeldReservation(u, res.Name, res.Env)
+		if cu == nil {
+			h.errorReply(ea, fmt.Sprintf(msgYouCannotKeepY, res))
+			continue
+		}
+
+		err := h.data.UpdateReservation(u, res.Name, res.Env, func(held *models.Reservation) error {
+			if held.Nudges == 0 {
+				held.Nudges = 1
+			}
+			held.Nudged = time.Now()
+			return nil
+		})
+		if err != nil {
+			h.errorReply(ea, err.Error())
+			continue
+		}
+
+		h.reply(ea, fmt.Sprintf(msgYouAreKeepingY, res), false)
+	}
+
+	return nil
+}
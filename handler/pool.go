@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// parsePool returns the name and pattern of the pool in a resource list given as "any <pattern>" or
+// "pool:<name>". ok is false if the list is not a pool.
+func (h *Handler) parsePool(text string) (name, pattern string, ok bool, err error) {
+	text = strings.Trim(text, " `")
+	switch {
+	case strings.HasPrefix(text, "any "):
+		pattern = strings.Trim(strings.TrimPrefix(text, "any "), " `")
+		return pattern, pattern, true, nil
+	case strings.HasPrefix(text, "pool:"):
+		name = strings.TrimPrefix(text, "pool:")
+		pattern, ok := h.pools[name]
+		if !ok {
+			return name, "", true, e.PoolDoesNotExist
+		}
+		return name, pattern, true, nil
+	}
+	return "", "", false, nil
+}
+
+// getPoolMembers returns the resources matching a pool pattern, sorted by name. The pattern is a glob, e.g.
+// "qa|web*", and is matched against names within the pattern's env.
+func (h *Handler) getPoolMembers(pattern string) []*models.Resource {
+	var resources []*models.Resource
+	namePattern := pattern
+	if i := strings.Index(pattern, "|"); i >= 0 {
+		resources = h.data.GetResourcesForEnv(pattern[:i])
+		namePattern = pattern[i+1:]
+	} else {
+		resources = h.data.GetResources()
+	}
+
+	ret := []*models.Resource{}
+	for _, r := range resources {
+		if ok, _ := path.Match(namePattern, r.Name); ok {
+			ret = append(ret, r)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret
+}
+
+// reservePool reserves the first free member of a pool. If every member is taken, the user is queued for
+// all of them and keeps whichever frees first.
+func (h *Handler) reservePool(ea *EventAction, u *models.User, name, pattern string, hold time.Duration, note string, priority models.Priority, by *models.User) error {
+	members := h.getPoolMembers(pattern)
+	if len(members) == 0 {
+		h.errorReply(ea, fmt.Sprintf(msgNoResourcesInPoolX, name))
+		return nil
+	}
+
+	var free *models.Resource
+	// Members the user is already in line for on their own are left alone rather than turned into pool
+	// reservations
+	open := []*models.Resource{}
+	for _, r := range members {
+		res := h.data.GetReservation(u, r.Name, r.Env)
+		if res != nil && res.Pool == name {
+			if by != nil {
+				h.reply(ea, fmt.Sprintf(msgXIsAlreadyInPoolY, h.getUserDisplay(u, false), name), true)
+				return nil
+			}
+			h.reply(ea, fmt.Sprintf(msgYouAreAlreadyInPoolX, name), true)
+			return nil
+		}
+		if res != nil {
+			if h.getHeldReservation(u, r.Name, r.Env) == nil {
+				continue
+			}
+			if by != nil {
+				h.reply(ea, fmt.Sprintf(msgXAlreadyHasYFromPoolZ, h.getUserDisplay(u, false), r, name), true)
+				return nil
+			}
+			h.reply(ea, fmt.Sprintf(msgYouAlreadyHaveYFromPoolX, r, name), true)
+			return nil
+		}
+		open = append(open, r)
+		q, err := h.data.GetQueueForResource(r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		if free == nil && len(q.Reservations) < r.Holders() {
+			free = r
+		}
+	}
+	if len(open) == 0 {
+		h.reply(ea, msgAlreadyInAllQueues, true)
+		return nil
+	}
+
+	queued := open
+	if free != nil {
+		queued = []*models.Resource{free}
+	}
+	failed := []string{}
+	for _, r := range queued {
+		err := h.watchQueue(r.Name, r.Env, func() error {
+			return h.data.Reserve(u, r.Name, r.Env)
+		})
+		if err != nil && err != e.AlreadyInQueue {
+			failed = append(failed, fmt.Sprintf(msgYColonX, r, err))
+			continue
+		}
+		err = h.data.UpdateReservation(u, r.Name, r.Env, func(res *models.Reservation) error {
+			res.Pool = name
+			res.Priority = priority
+			res.CreatedBy = by
+			if hold > 0 {
+				res.Hold = hold
+			}
+			if note != "" {
+				res.Note = note
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		err = h.prioritize(u, r.Name, r.Env)
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+
+	if len(failed) == len(queued) {
+		h.errorReply(ea, strings.Join(failed, "\n"))
+		return nil
+	}
+	if len(failed) > 0 {
+		h.errorReply(ea, strings.Join(failed, "\n"))
+	}
+
+	msg := fmt.Sprintf(msgAllOfPoolXTakenQueuedForN, name, len(queued)-len(failed))
+	if free != nil {
+		msg = fmt.Sprintf(msgYouHaveYFromPoolX, free, name)
+	}
+	requester := u
+	if by != nil {
+		requester = by
+		// The pool was reserved for someone else, so they get the news and the requester gets a receipt
+		err := h.sendDM(u, fmt.Sprintf(msgXMadeAReservationForYouZ, h.getUserDisplay(by, false), msg))
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		msg = fmt.Sprintf(msgYouHaveReservedYForX, "pool:"+name, h.getUserDisplay(u, false))
+	}
+	err := h.reply(ea, msg, true)
+	h.warnDeadlocks(ea, requester, u)
+	return err
+}
+
+// getPoolReservations returns the resources of a pool for which the user is waiting
+func (h *Handler) getPoolReservations(u *models.User, name, pattern string) []*models.Resource {
+	ret := []*models.Resource{}
+	for _, r := range h.getPoolMembers(pattern) {
+		res := h.data.GetReservation(u, r.Name, r.Env)
+		if res != nil && res.Pool == name {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// settlePools makes sure every user holds at most one member of each pool they reserved from. Once a user
+// has a member, they are removed from the queues of the others. If they ended up with more than one, the
+// extras are released to the next in line.
+func (h *Handler) settlePools() {
+	type poolUser struct {
+		pool string
+		user string
+	}
+
+	queues := h.data.GetQueues()
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Resource.String() < queues[j].Resource.String()
+	})
+
+	holding := map[poolUser]*models.Resource{}
+	for _, q := range queues {
+		for _, holder := range q.Holders() {
+			if holder.Pool == "" {
+				continue
+			}
+			pu := poolUser{holder.Pool, holder.User.ID}
+			if _, ok := holding[pu]; !ok {
+				holding[pu] = q.Resource
+			}
+		}
+	}
+
+	for _, q := range queues {
+		for _, res := range q.Reservations {
+			if res.Pool == "" {
+				continue
+			}
+			kept, ok := holding[poolUser{res.Pool, res.User.ID}]
+			if !ok || kept.Key() == q.Resource.Key() {
+				continue
+			}
+
+			r := q.Resource
+			pos, err := h.data.GetPosition(res.User, r.Name, r.Env)
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			held := h.isHolder(r.Name, r.Env, pos)
+			cu := h.getNextInLine(r.Name, r.Env)
+			err = h.watchQueue(r.Name, r.Env, func() error {
+				return h.data.Remove(res.User, r.Name, r.Env)
+			})
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+
+			// If they were holding an extra member, it goes to the next in line
+			if held && cu != nil {
+				err = h.sendDM(cu.User, fmt.Sprintf(msgXHasReleasedYItIsYours, h.getUserDisplay(res.User, false), r))
+				if err != nil {
+					log.Errorf("%+v", err)
+				}
+			}
+		}
+	}
+}
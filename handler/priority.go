@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	"github.com/ameliagapin/reservebot/util"
+	log "github.com/sirupsen/logrus"
+)
+
+var priorityRegex = regexp.MustCompile(`^(.+?)\s+priority\s+(\S+)$`)
+
+// parsePriority splits an optional "priority <level>" suffix from a resource list
+func parsePriority(text string) (string, models.Priority, error) {
+	matches := priorityRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return text, models.PriorityNormal, nil
+	}
+	p, ok := models.ParsePriority(strings.ToLower(matches[2]))
+	if !ok {
+		return text, models.PriorityNormal, e.InvalidPriority
+	}
+	return matches[1], p, nil
+}
+
+// canPrioritize returns whether a user may reserve with a priority. Anyone can lower their priority, but
+// only admins and the users allowed to prioritize can raise it.
+func (h *Handler) canPrioritize(u *models.User, p models.Priority) bool {
+	return p <= models.PriorityNormal || h.HasAdminAccess(u.Name) || util.InSlice(h.prioritized, u.Name)
+}
+
+// getPriorityText returns the text marking a reservation's priority, if it is not normal
+func getPriorityText(res *models.Reservation) string {
+	if res.Priority == models.PriorityNormal {
+		return ""
+	}
+	return fmt.Sprintf(msgSpacePriorityX, res.Priority)
+}
+
+// prioritize moves the user's reservation of a resource, if they are waiting, ahead of every waiting user
+// with a lower priority. It never moves them back in line.
+func (h *Handler) prioritize(u *models.User, name, env string) error {
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil {
+		return err
+	}
+	holders := len(q.Holders())
+	idx := q.Find(u)
+	if idx < holders {
+		return nil
+	}
+	me := q.Reservations[idx]
+	rest := append(append([]*models.Reservation{}, q.Reservations[:idx]...), q.Reservations[idx+1:]...)
+
+	// to is where the reservation goes once it has been taken out of the queue
+	to := holders
+	for i := holders; i < len(rest); i++ {
+		if rest[i].Priority >= me.Priority {
+			to = i + 1
+		}
+	}
+	if to >= idx {
+		return nil
+	}
+
+	return h.data.Move(u, name, env, to+1)
+}
+
+// getHolders returns a resource's holders, keyed by ID
+func (h *Handler) getHolders(name, env string) map[string]*models.User {
+	ret := map[string]*models.User{}
+	q, err := h.data.GetQueueForResource(name, env)
+	if err != nil {
+		return ret
+	}
+	for _, res := range q.Holders() {
+		ret[res.User.ID] = res.User
+	}
+	return ret
+}
+
+// announceReorder notifies users who became holders of a resource, or stopped being holders, because an
+// admin reordered its queue. held are the holders before the change.
+func (h *Handler) announceReorder(ea *EventAction, admin *models.User, res *models.Resource, held map[string]*models.User) {
+	now := h.getHolders(res.Name, res.Env)
+	// The admin already knows what they did
+	delete(now, admin.ID)
+	delete(held, admin.ID)
+	for id, u := range now {
+		if _, ok := held[id]; !ok && h.getHeldReservation(u, res.Name, res.Env) != nil {
+			h.announce(ea, u, fmt.Sprintf(msgXReorderedYItIsYours, h.getUserDisplay(admin, false), res))
+		}
+	}
+	for id, u := range held {
+		if _, ok := now[id]; !ok {
+			h.announce(ea, u, fmt.Sprintf(msgXReorderedYBackInLine, h.getUserDisplay(admin, false), res))
+		}
+	}
+}
+
+// move moves a user to a position in a resource's queue
+func (h *Handler) move(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `move`.", false)
+		return nil
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	target, err := h.getUser(matches[0])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+	pos, err := strconv.Atoi(matches[1])
+	if err != nil || pos < 1 {
+		h.errorReply(ea, msgInvalidPosition)
+		return nil
+	}
+	res, err := h.parseResource(strings.Trim(matches[2], " `"))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	held := h.getHolders(res.Name, res.Env)
+	err = h.watchQueue(res.Name, res.Env, func() error {
+		return h.data.Move(target, res.Name, res.Env, pos)
+	})
+	if err != nil {
+		h.handleReorderError(ea, err, target, res)
+		return nil
+	}
+
+	pos, _ = h.data.GetPosition(target, res.Name, res.Env)
+	h.reply(ea, fmt.Sprintf(msgXIsNowNInLineForY, h.getUserDisplay(target, false), util.Ordinalize(pos), res), false)
+	h.announceReorder(ea, u, res, held)
+	h.warnDeadlocks(ea, u, target)
+
+	return nil
+}
+
+// swap swaps the places of two users in a resource's queue
+func (h *Handler) swap(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	if !h.HasAdminAccess(u.Name) {
+		h.reply(ea, "Error, your user is not authorized to run the command `swap`.", false)
+		return nil
+	}
+
+	matches := h.getMatches(ea.Action, ev.Text)
+	a, err := h.getUser(matches[0])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+	b, err := h.getUser(matches[1])
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.reply(ea, msgUknownUser, true)
+		return err
+	}
+	res, err := h.parseResource(strings.Trim(matches[2], " `"))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return err
+	}
+
+	held := h.getHolders(res.Name, res.Env)
+	err = h.watchQueue(res.Name, res.Env, func() error {
+		return h.data.Swap(a, b, res.Name, res.Env)
+	})
+	if err != nil {
+		// Only one of them can be reported, so find out which one is missing
+		missing := a
+		if pos, _ := h.data.GetPosition(a, res.Name, res.Env); pos > 0 {
+			missing = b
+		}
+		h.handleReorderError(ea, err, missing, res)
+		return nil
+	}
+
+	h.reply(ea, fmt.Sprintf(msgXAndYHaveSwappedPlacesForZ, h.getUserDisplay(a, false), h.getUserDisplay(b, false), res), false)
+	h.announceReorder(ea, u, res, held)
+	h.warnDeadlocks(ea, u, a, b)
+
+	return nil
+}
+
+func (h *Handler) handleReorderError(ea *EventAction, err error, u *models.User, res *models.Resource) {
+	switch err {
+	case e.ResourceDoesNotExist:
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+	case e.NotInQueue:
+		h.errorReply(ea, fmt.Sprintf(msgXIsNotInLineForY, h.getUserDisplay(u, false), res))
+	default:
+		h.errorReply(ea, err.Error())
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ameliagapin/reservebot/data"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		text     string
+		rest     string
+		priority models.Priority
+		err      error
+	}{
+		{"qa|web", "qa|web", models.PriorityNormal, nil},
+		{"qa|web priority high", "qa|web", models.PriorityHigh, nil},
+		{"qa|web priority LOW", "qa|web", models.PriorityLow, nil},
+		{"qa|web priority normal", "qa|web", models.PriorityNormal, nil},
+		{"qa|web, qa|api  priority  high", "qa|web, qa|api", models.PriorityHigh, nil},
+		{"qa|web priority high now", "qa|web priority high now", models.PriorityNormal, nil},
+		{"priority high", "priority high", models.PriorityNormal, nil},
+		{"qa|web priority urgent", "qa|web priority urgent", models.PriorityNormal, e.InvalidPriority},
+	}
+	for _, tt := range tests {
+		rest, priority, err := parsePriority(tt.text)
+		if rest != tt.rest || priority != tt.priority || err != tt.err {
+			t.Errorf("%q: got %q, %s, %v, want %q, %s, %v", tt.text, rest, priority, err, tt.rest, tt.priority, tt.err)
+		}
+	}
+}
+
+// queued is a user in line for a resource with a priority
+type queued struct {
+	name     string
+	priority models.Priority
+}
+
+func TestPrioritize(t *testing.T) {
+	low, normal, high := models.PriorityLow, models.PriorityNormal, models.PriorityHigh
+	tests := []struct {
+		name     string
+		capacity int
+		queue    []queued
+		user     string
+		want     string
+	}{
+		{"alone", 1, []queued{{"a", normal}, {"x", high}}, "x", "a,x"},
+		{"behind the same priority", 1, []queued{{"a", normal}, {"b", normal}, {"x", normal}}, "x", "a,b,x"},
+		{"ahead of lower priorities", 1, []queued{{"a", low}, {"b", normal}, {"c", low}, {"x", high}}, "x", "a,x,b,c"},
+		{"behind higher and equal priorities", 1, []queued{{"a", normal}, {"b", high}, {"c", high}, {"d", normal}, {"x", high}}, "x", "a,b,c,x,d"},
+		{"between", 1, []queued{{"a", normal}, {"b", high}, {"c", low}, {"x", normal}}, "x", "a,b,x,c"},
+		{"low stays last", 1, []queued{{"a", normal}, {"b", low}, {"x", low}}, "x", "a,b,x"},
+		{"never back in line", 1, []queued{{"a", normal}, {"x", low}, {"b", high}}, "x", "a,x,b"},
+		{"never ahead of holders", 2, []queued{{"a", low}, {"b", low}, {"c", normal}, {"x", high}}, "x", "a,b,x,c"},
+		{"holders stay put", 2, []queued{{"a", normal}, {"x", high}}, "x", "a,x"},
+	}
+	for _, tt := range tests {
+		m := data.NewMemory()
+		h := &Handler{data: m}
+		if err := m.Create("web", "qa"); err != nil {
+			t.Fatal(err)
+		}
+		err := m.UpdateResource("web", "qa", func(r *models.Resource) error {
+			r.Capacity = tt.capacity
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users := map[string]*models.User{}
+		for _, qu := range tt.queue {
+			u := &models.User{ID: qu.name, Name: qu.name}
+			users[qu.name] = u
+			if err := m.Reserve(u, "web", "qa"); err != nil {
+				t.Fatal(err)
+			}
+			priority := qu.priority
+			err := m.UpdateReservation(u, "web", "qa", func(res *models.Reservation) error {
+				res.Priority = priority
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := h.prioritize(users[tt.user], "web", "qa"); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		q, _ := m.GetQueueForResource("web", "qa")
+		got := []string{}
+		for _, res := range q.Reservations {
+			got = append(got, res.User.Name)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, strings.Join(got, ","), tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package handler
+
+// Settle reconciles reservations that span more than one queue after any queue has changed, starts and ends
+// bookings and has App Homes refreshed in the background. It is run after every command and periodically,
+// since holds can also change when they expire.
+func (h *Handler) Settle() {
+	h.settleLock.Lock()
+	defer h.settleLock.Unlock()
+
+	h.settleBookings()
+	h.settlePools()
+	h.settleComposites()
+	h.settleHomes()
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// slashCommands maps each slash command to the command it runs, with the text of the slash command appended
+var slashCommands = map[string]string{
+	"/reserve":    "reserve",
+	"/release":    "release",
+	"/rstatus":    "status",
+	"/reservebot": "",
+}
+
+// SlashCommand runs a slash command the same way as the matching direct message, except that replies are only
+// shown to the user who ran it
+func (h *Handler) SlashCommand(cmd slack.SlashCommand) error {
+	text, ok := slashCommands[cmd.Command]
+	if !ok {
+		return fmt.Errorf("unknown slash command %s", cmd.Command)
+	}
+	args := strings.TrimSpace(cmd.Text)
+	switch {
+	case cmd.Command == "/rstatus" && args == "me":
+		text = "my status"
+	case args != "":
+		text = strings.TrimSpace(text + " " + args)
+	}
+
+	err := h.handlePrivate(cmd.UserID, cmd.ChannelID, cmd.ResponseURL, text)
+	h.Settle()
+	return err
+}
+
+// handlePrivate runs a command for a user as if it had been sent in a DM, with the replies sent to responseURL
+// so that only the user sees them
+func (h *Handler) handlePrivate(user, channel, responseURL, text string) error {
+	ea := &EventAction{
+		Event: &slackevents.MessageEvent{
+			Type:    "message",
+			User:    user,
+			Text:    text,
+			Channel: channel,
+			// Replies are private, so they are worded as they are in direct messages
+			ChannelType: "im",
+		},
+		ResponseURL: responseURL,
+	}
+	ea.Action = h.getAction(ea.Event.Text)
+	return h.handle(ea)
+}
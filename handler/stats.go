@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ameliagapin/reservebot/data"
+	e "github.com/ameliagapin/reservebot/err"
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// statsWindow is how far back stats look by default
+const statsWindow = "7d"
+
+// parseSince parses how far back to look, as a duration or a number of days, e.g. "24h" or "7d"
+func parseSince(text string) (time.Duration, error) {
+	if strings.HasSuffix(text, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+		if err != nil || days <= 0 {
+			return 0, e.InvalidDuration
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d <= 0 {
+		return 0, e.InvalidDuration
+	}
+	return d, nil
+}
+
+// stats are the usage figures of a resource, or of every resource in an env, over a window of time
+type stats struct {
+	Name string
+	// Reservations is how many times users joined the queue
+	Reservations int
+	// Held is how long at least one user held the resource and Idle how long nobody did
+	Held time.Duration
+	Idle time.Duration
+	// Waits are how long each user who got the resource waited in line for it
+	Waits []time.Duration
+	// PeakQueue is the most users that were waiting at once
+	PeakQueue int
+}
+
+// add folds the figures of another resource into s
+func (s *stats) add(o *stats) {
+	s.Reservations += o.Reservations
+	s.Held += o.Held
+	s.Idle += o.Idle
+	s.Waits = append(s.Waits, o.Waits...)
+	if o.PeakQueue > s.PeakQueue {
+		s.PeakQueue = o.PeakQueue
+	}
+}
+
+// percentile returns the wait that p of the waits are no longer than, using the nearest rank
+func (s *stats) percentile(p float64) time.Duration {
+	if len(s.Waits) == 0 {
+		return 0
+	}
+	waits := append([]time.Duration{}, s.Waits...)
+	sort.Slice(waits, func(i, j int) bool {
+		return waits[i] < waits[j]
+	})
+	idx := int(math.Ceil(p*float64(len(waits)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return waits[idx]
+}
+
+// utilization returns the percentage of time the resource was held
+func (s *stats) utilization() int {
+	total := s.Held + s.Idle
+	if total <= 0 {
+		return 0
+	}
+	return int(100 * s.Held / total)
+}
+
+// resourceTracker follows a single resource while the history is replayed
+type resourceTracker struct {
+	stats
+	name, env string
+
+	// exists and busy are set while the resource exists and while someone holds it, since the times in
+	// existsSince and busySince
+	exists      bool
+	existsSince time.Time
+	busy        bool
+	busySince   time.Time
+	// existed is how long the resource existed during the window
+	existed time.Duration
+	// waitingAtStart is how many users were waiting when the window started
+	waitingAtStart int
+
+	// joined and holding are when each user in the queue joined it and when each holder started holding
+	joined  map[string]time.Time
+	holding map[string]time.Time
+}
+
+// clip returns how much of the time from a to b falls within the window
+func clip(a, b, from, to time.Time) time.Duration {
+	if a.Before(from) {
+		a = from
+	}
+	if b.After(to) {
+		b = to
+	}
+	if !b.After(a) {
+		return 0
+	}
+	return b.Sub(a)
+}
+
+// update compares the resource's queue after an event with what it was before
+func (t *resourceTracker) update(m *data.Memory, at, from, to time.Time) {
+	q, err := m.GetQueueForResource(t.name, t.env)
+	if err != nil {
+		q = nil
+	}
+
+	switch {
+	case q != nil && !t.exists:
+		t.exists, t.existsSince = true, at
+	case q == nil && t.exists:
+		t.exists = false
+		t.existed += clip(t.existsSince, at, from, to)
+	}
+
+	joined := map[string]time.Time{}
+	holding := map[string]time.Time{}
+	if q != nil {
+		for _, res := range q.Reservations {
+			if j, ok := t.joined[res.User.ID]; ok {
+				joined[res.User.ID] = j
+			} else {
+				joined[res.User.ID] = at
+			}
+		}
+		for _, res := range q.Holders() {
+			// A place set aside for a pending composite request is still waited for
+			if res.Pending() {
+				continue
+			}
+			if since, ok := t.holding[res.User.ID]; ok {
+				holding[res.User.ID] = since
+				continue
+			}
+			holding[res.User.ID] = at
+			if !at.Before(from) {
+				t.Waits = append(t.Waits, at.Sub(joined[res.User.ID]))
+			}
+		}
+	}
+	waiting := len(joined) - len(holding)
+	if at.Before(from) {
+		t.waitingAtStart = waiting
+	} else if waiting > t.PeakQueue {
+		t.PeakQueue = waiting
+	}
+	t.joined, t.holding = joined, holding
+
+	switch {
+	case len(holding) > 0 && !t.busy:
+		t.busy, t.busySince = true, at
+	case len(holding) == 0 && t.busy:
+		t.busy = false
+		t.Held += clip(t.busySince, at, from, to)
+	}
+}
+
+// finish closes the times that are still open at the end of the window
+func (t *resourceTracker) finish(from, to time.Time) {
+	if t.busy {
+		t.Held += clip(t.busySince, to, from, to)
+	}
+	if t.exists {
+		t.existed += clip(t.existsSince, to, from, to)
+	}
+	if t.waitingAtStart > t.PeakQueue {
+		t.PeakQueue = t.waitingAtStart
+	}
+	t.Idle = t.existed - t.Held
+	if t.Idle < 0 {
+		t.Idle = 0
+	}
+}
+
+// getStats replays the history to work out the stats of every resource since from, keyed by resource key
+func (h *Handler) getStats(from time.Time) map[string]*resourceTracker {
+	to := time.Now()
+	trackers := map[string]*resourceTracker{}
+
+	data.Walk(h.data.GetEvents(time.Time{}), func(ev *models.Event, m *data.Memory) {
+		if ev.Time.After(to) {
+			return
+		}
+
+		// changed are the resources of the event. Events without any, like a nuke, can change every queue.
+		changed := map[string]bool{}
+		resources := ev.Resources
+		if ev.Name != "" {
+			resources = []*models.Resource{{Name: ev.Name, Env: ev.Env}}
+		}
+		for _, r := range resources {
+			key := r.Key()
+			changed[key] = true
+			if _, ok := trackers[key]; !ok {
+				trackers[key] = &resourceTracker{name: r.Name, env: r.Env}
+			}
+			if (ev.Type == models.Reserved || ev.Type == models.ReservedAll) && !ev.Time.Before(from) {
+				trackers[key].Reservations++
+			}
+		}
+
+		for key, t := range trackers {
+			if len(changed) == 0 || changed[key] {
+				t.update(m, ev.Time, from, to)
+			}
+		}
+	})
+
+	for key, t := range trackers {
+		t.finish(from, to)
+		if !t.exists && t.existed == 0 && t.Reservations == 0 {
+			delete(trackers, key)
+			continue
+		}
+		t.Name = (&models.Resource{Name: t.name, Env: t.env}).String()
+	}
+	return trackers
+}
+
+func (h *Handler) getStatsText(s *stats) string {
+	return fmt.Sprintf(msgStatsX, s.Name, s.Reservations, formatDuration(s.Held), s.utilization(), formatDuration(s.Idle),
+		formatDuration(s.percentile(0.5)), formatDuration(s.percentile(0.95)), s.PeakQueue)
+}
+
+// getStatsCSV returns the stats as CSV, with durations in minutes
+func getStatsCSV(rows []*stats) (string, error) {
+	minutes := func(d time.Duration) string {
+		return strconv.Itoa(int(d.Minutes()))
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"resource", "reservations", "held_minutes", "idle_minutes", "median_wait_minutes", "p95_wait_minutes", "peak_queue"})
+	for _, s := range rows {
+		w.Write([]string{s.Name, strconv.Itoa(s.Reservations), minutes(s.Held), minutes(s.Idle), minutes(s.percentile(0.5)), minutes(s.percentile(0.95)), strconv.Itoa(s.PeakQueue)})
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
+
+// statsCmd reports usage stats. With no resource there is a row for every env, with an env there is a row
+// for each of its resources and one for the env as a whole, and with a resource there is a row for it alone.
+// The window defaults to statsWindow and "csv" uploads the rows as a CSV file instead.
+func (h *Handler) statsCmd(ea *EventAction) error {
+	ev := ea.Event
+
+	since := statsWindow
+	asCSV := false
+	target := ""
+	matches := h.getMatches(ea.Action, ev.Text)
+	for _, arg := range strings.Fields(matches[0]) {
+		arg = strings.Trim(arg, "`")
+		if arg == "csv" {
+			asCSV = true
+			continue
+		}
+		if _, err := parseSince(arg); err == nil && !strings.Contains(arg, "|") {
+			since = arg
+			continue
+		}
+		target = arg
+	}
+	window, _ := parseSince(since)
+
+	trackers := h.getStats(time.Now().Add(-window))
+
+	rows := []*stats{}
+	switch {
+	case target == "":
+		envs := map[string]*stats{}
+		for _, t := range trackers {
+			env, ok := envs[t.env]
+			if !ok {
+				name := t.env
+				if name == "" {
+					name = "global"
+				}
+				env = &stats{Name: name}
+				envs[t.env] = env
+			}
+			env.add(&t.stats)
+		}
+		for _, s := range envs {
+			rows = append(rows, s)
+		}
+	case !strings.Contains(target, "|") && len(getEnvTrackers(trackers, target)) > 0:
+		total := &stats{Name: target}
+		for _, t := range getEnvTrackers(trackers, target) {
+			rows = append(rows, &t.stats)
+			total.add(&t.stats)
+		}
+		rows = append(rows, total)
+	default:
+		res, err := h.parseResource(target)
+		if err != nil || res == nil {
+			h.handleGetResourceError(ea, err)
+			return err
+		}
+		t, ok := trackers[res.Key()]
+		if !ok {
+			return h.reply(ea, fmt.Sprintf(msgNoStatsForY, res), false)
+		}
+		rows = append(rows, &t.stats)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Name < rows[j].Name
+	})
+
+	if len(rows) == 0 {
+		return h.reply(ea, msgNoStats, false)
+	}
+
+	if asCSV {
+		content, err := getStatsCSV(rows)
+		if err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, "")
+			return err
+		}
+		_, err = h.client.UploadFile(slack.FileUploadParameters{
+			Content:  content,
+			Filetype: "csv",
+			Filename: "reservebot-stats.csv",
+			Channels: []string{ev.Channel},
+		})
+		if err != nil {
+			log.Errorf("%+v", err)
+			h.errorReply(ea, "")
+		}
+		return err
+	}
+
+	lines := []string{}
+	for _, s := range rows {
+		lines = append(lines, h.getStatsText(s))
+	}
+	return h.reply(ea, fmt.Sprintf(msgStatsForTheLastXY, since, strings.Join(lines, "\n")), false)
+}
+
+// getEnvTrackers returns the trackers of the resources in an env
+func getEnvTrackers(trackers map[string]*resourceTracker, env string) []*resourceTracker {
+	ret := []*resourceTracker{}
+	for _, t := range trackers {
+		if t.env == env {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
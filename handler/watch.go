@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ameliagapin/reservebot/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// getWatchedResource returns the resource named in a watch command, replying with an error if it does not
+// exist
+func (h *Handler) getWatchedResource(ea *EventAction) *models.Resource {
+	matches := h.getMatches(ea.Action, ea.Event.Text)
+	res, err := h.parseResource(strings.Trim(matches[0], " `"))
+	if err != nil || res == nil {
+		h.handleGetResourceError(ea, err)
+		return nil
+	}
+	r := h.data.GetResource(res.Name, res.Env, false)
+	if r == nil {
+		h.errorReply(ea, fmt.Sprintf(msgResourceDoesNotExistY, res))
+	}
+	return r
+}
+
+// watch asks to be told when a resource becomes free or changes holder, without joining its queue
+func (h *Handler) watch(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	r := h.getWatchedResource(ea)
+	if r == nil {
+		return nil
+	}
+
+	err = h.data.UpdateResource(r.Name, r.Env, func(watched *models.Resource) error {
+		if watched.IsWatchedBy(u) {
+			return errUnchanged
+		}
+		watched.Watchers = append(append([]*models.User{}, watched.Watchers...), u)
+		return nil
+	})
+	if err == errUnchanged {
+		return h.reply(ea, fmt.Sprintf(msgYouAreAlreadyWatchingY, r), true)
+	}
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgYouAreNowWatchingY, r), true)
+}
+
+func (h *Handler) unwatch(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	r := h.getWatchedResource(ea)
+	if r == nil {
+		return nil
+	}
+
+	err = h.data.UpdateResource(r.Name, r.Env, func(watched *models.Resource) error {
+		if !watched.IsWatchedBy(u) {
+			return errUnchanged
+		}
+		watchers := []*models.User{}
+		for _, w := range watched.Watchers {
+			if w.ID != u.ID {
+				watchers = append(watchers, w)
+			}
+		}
+		watched.Watchers = watchers
+		return nil
+	})
+	if err == errUnchanged {
+		return h.reply(ea, fmt.Sprintf(msgYouAreNotWatchingY, r), true)
+	}
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, err.Error())
+		return err
+	}
+
+	return h.reply(ea, fmt.Sprintf(msgYouAreNoLongerWatchingY, r), true)
+}
+
+// myWatches lists the resources the user is watching along with their status
+func (h *Handler) myWatches(ea *EventAction) error {
+	ev := ea.Event
+	u, err := h.getUser(ev.User)
+	if err != nil {
+		log.Errorf("%+v", err)
+		h.errorReply(ea, "")
+		return err
+	}
+
+	resources := h.data.GetResources()
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].String() < resources[j].String()
+	})
+
+	lines := []string{}
+	for _, r := range resources {
+		if !r.IsWatchedBy(u) {
+			continue
+		}
+		text, err := h.getCurrentResText(r, false)
+		if err != nil {
+			log.Errorf("%+v", err)
+			continue
+		}
+		lines = append(lines, text)
+	}
+
+	if len(lines) == 0 {
+		return h.reply(ea, msgYouAreNotWatchingAnything, true)
+	}
+	return h.reply(ea, fmt.Sprintf(msgYouAreWatchingX, strings.Join(lines, "\n")), true)
+}
+
+// watchQueue runs a change to a resource's queue, then tells its watchers who has it now if that is no longer
+// who had it before. Every path that can free a resource or hand it to someone else goes through here.
+func (h *Handler) watchQueue(name, env string, change func() error) error {
+	return h.watchQueues([]*models.Resource{{Name: name, Env: env}}, change)
+}
+
+// watchQueues is watchQueue for a change to several resources at once
+func (h *Handler) watchQueues(resources []*models.Resource, change func() error) error {
+	before := map[string]map[string]*models.User{}
+	for _, r := range resources {
+		before[r.Key()] = h.getHolders(r.Name, r.Env)
+	}
+	err := change()
+	for _, r := range resources {
+		h.notifyWatchers(r.Name, r.Env, before[r.Key()])
+	}
+	return err
+}
+
+// notifyWatchers tells the watchers of a resource that it is free or has changed hands, if its holders are no
+// longer those in before
+func (h *Handler) notifyWatchers(name, env string, before map[string]*models.User) {
+	after := h.getHolders(name, env)
+
+	changed := len(before) != len(after)
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	r := h.data.GetResource(name, env, false)
+	if r == nil {
+		return
+	}
+	names := []string{}
+	for _, u := range after {
+		names = append(names, h.getUserDisplay(u, false))
+	}
+	sort.Strings(names)
+
+	msg := fmt.Sprintf(msgYIsNowFree, r)
+	if len(names) > 0 {
+		msg = fmt.Sprintf(msgYHasChangedHandsXHasItNow, r, strings.Join(names, ", "))
+	}
+	for _, w := range r.Watchers {
+		if _, ok := after[w.ID]; ok {
+			continue
+		}
+		derr := h.sendDM(w, msg)
+		if derr != nil {
+			log.Errorf("%+v", derr)
+		}
+	}
+}
+
+// watchRemoval runs a change that can remove resources, then tells the watchers of every resource that is
+// gone that they are no longer watching it
+func (h *Handler) watchRemoval(change func() error) error {
+	watched := []*models.Resource{}
+	for _, r := range h.data.GetResources() {
+		if len(r.Watchers) > 0 {
+			watched = append(watched, r)
+		}
+	}
+	err := change()
+
+	for _, r := range watched {
+		if h.data.GetResource(r.Name, r.Env, false) != nil {
+			continue
+		}
+		for _, w := range r.Watchers {
+			derr := h.sendDM(w, fmt.Sprintf(msgYHasBeenRemoved, r))
+			if derr != nil {
+				log.Errorf("%+v", derr)
+			}
+		}
+	}
+	return err
+}
+
+// Prune removes resources without reservations that have been inactive for the given number of hours,
+// telling their watchers
+func (h *Handler) Prune(hours int) error {
+	return h.watchRemoval(func() error {
+		return h.data.PruneInactiveResources(hours)
+	})
+}
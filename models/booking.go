@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+)
+
+// Booking reserves a resource for a user during a window of time. At the start of the window the user is
+// moved ahead of anyone waiting, and at the end their reservation is released. A recurring booking repeats
+// on the days in Every, and Start and End are the window of its next occurrence.
+type Booking struct {
+	ID    string    `json:"id"`
+	User  *User     `json:"user"`
+	Name  string    `json:"name"`
+	Env   string    `json:"env"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Active is set while the booking is being enforced
+	Active bool `json:"active,omitempty"`
+	// Every is the days of the week on which a recurring booking starts
+	Every []time.Weekday `json:"every,omitempty"`
+	// Paused recurring bookings skip their occurrences until they are resumed
+	Paused bool `json:"paused,omitempty"`
+}
+
+func (b *Booking) Key() string {
+	return ResourceKey(b.Name, b.Env)
+}
+
+// Overlaps returns whether the booking's window overlaps the window from start to end
+func (b *Booking) Overlaps(start, end time.Time) bool {
+	return b.Start.Before(end) && start.Before(b.End)
+}
+
+func (b *Booking) Recurring() bool {
+	return len(b.Every) > 0
+}
+
+// RepeatsOn returns whether a recurring booking has an occurrence starting on day
+func (b *Booking) RepeatsOn(day time.Weekday) bool {
+	for _, d := range b.Every {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Next moves a recurring booking's window to its next occurrence. The window keeps its local start and end
+// times, so that it is the same on the clock even when daylight saving time starts or ends in between.
+func (b *Booking) Next() {
+	days := 0
+	for days < 7 {
+		days++
+		if b.RepeatsOn(b.Start.AddDate(0, 0, days).Weekday()) {
+			break
+		}
+	}
+	b.Start = b.Start.AddDate(0, 0, days)
+	b.End = b.End.AddDate(0, 0, days)
+}
+
+// Occurrences returns a copy of the booking for each of its occurrences that start before until. A one-off
+// booking has a single occurrence.
+func (b *Booking) Occurrences(until time.Time) []*Booking {
+	ret := []*Booking{}
+	c := *b
+	for c.Start.Before(until) {
+		o := c
+		ret = append(ret, &o)
+		if !c.Recurring() {
+			break
+		}
+		c.Next()
+	}
+	return ret
+}
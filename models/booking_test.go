@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBookingNextAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2021, month, day, hour, 0, 0, 0, ny)
+	}
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	const layout = "Mon Jan 2 15:04 MST"
+
+	tests := []struct {
+		name       string
+		every      []time.Weekday
+		start, end time.Time
+		wantStart  string
+		wantEnd    string
+	}{
+		{"daily into DST", []time.Weekday{time.Saturday, time.Sunday}, at(time.March, 13, 9), at(time.March, 13, 17), "Sun Mar 14 09:00 EDT", "Sun Mar 14 17:00 EDT"},
+		{"daily across the change", []time.Weekday{time.Saturday, time.Sunday}, at(time.March, 13, 1), at(time.March, 13, 4), "Sun Mar 14 01:00 EST", "Sun Mar 14 04:00 EDT"},
+		{"weekly into DST", []time.Weekday{time.Monday}, at(time.March, 8, 9), at(time.March, 8, 10), "Mon Mar 15 09:00 EDT", "Mon Mar 15 10:00 EDT"},
+		{"over the weekend into DST", weekdays, at(time.March, 12, 18), at(time.March, 13, 8), "Mon Mar 15 18:00 EDT", "Tue Mar 16 08:00 EDT"},
+		{"daily out of DST", []time.Weekday{time.Saturday, time.Sunday}, at(time.November, 6, 9), at(time.November, 6, 17), "Sun Nov 7 09:00 EST", "Sun Nov 7 17:00 EST"},
+		{"weekly out of DST", []time.Weekday{time.Friday}, at(time.November, 5, 23), at(time.November, 6, 1), "Fri Nov 12 23:00 EST", "Sat Nov 13 01:00 EST"},
+	}
+	for _, tt := range tests {
+		b := &Booking{Every: tt.every, Start: tt.start, End: tt.end}
+		b.Next()
+		if got := b.Start.Format(layout); got != tt.wantStart {
+			t.Errorf("%s: starts %s, want %s", tt.name, got, tt.wantStart)
+		}
+		if got := b.End.Format(layout); got != tt.wantEnd {
+			t.Errorf("%s: ends %s, want %s", tt.name, got, tt.wantEnd)
+		}
+	}
+}
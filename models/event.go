@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	ResourceCreated EventType = "ResourceCreated"
+	ResourceRemoved EventType = "ResourceRemoved"
+	EnvRemoved      EventType = "EnvRemoved"
+	Reserved        EventType = "Reserved"
+	// Released is recorded when the holder of a resource leaves its queue
+	Released EventType = "Released"
+	// Removed is recorded when a user who is waiting leaves a queue
+	Removed EventType = "Removed"
+	Kicked  EventType = "Kicked"
+	Cleared EventType = "Cleared"
+	Pruned  EventType = "Pruned"
+	Nuked   EventType = "Nuked"
+	// ResourceUpdated and ReservationUpdated carry the new attributes in Resource and Reservation
+	ResourceUpdated    EventType = "ResourceUpdated"
+	ReservationUpdated EventType = "ReservationUpdated"
+	// Moved is recorded when a user is moved to Position in a queue
+	Moved EventType = "Moved"
+	// Swapped is recorded when User and Target swap places in a queue
+	Swapped EventType = "Swapped"
+	// HandedOff is recorded when User hands a resource they hold over to Target
+	HandedOff EventType = "HandedOff"
+	// BookingAdded and BookingUpdated carry the booking in Booking. BookingRemoved only carries its ID.
+	BookingAdded   EventType = "BookingAdded"
+	BookingUpdated EventType = "BookingUpdated"
+	BookingRemoved EventType = "BookingRemoved"
+	// ReservedAll is recorded when User is queued for all of Resources at once, as a single all-or-nothing
+	// request, and GrantedAll when they are handed all of them at once
+	ReservedAll EventType = "ReservedAll"
+	GrantedAll  EventType = "GrantedAll"
+)
+
+// Event is a single change to the reservation state. Replaying all events in order reproduces the state.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	User *User     `json:"user,omitempty"`
+	Name string    `json:"name,omitempty"`
+	Env  string    `json:"env,omitempty"`
+
+	Resource    *Resource    `json:"resource,omitempty"`
+	Reservation *Reservation `json:"reservation,omitempty"`
+	Booking     *Booking     `json:"booking,omitempty"`
+	Position    int          `json:"position,omitempty"`
+	Target      *User        `json:"target,omitempty"`
+	// Resources are the resources of an all-or-nothing request. Only their names and envs are set.
+	Resources []*Resource `json:"resources,omitempty"`
+	// Held is how long User had held the resource, on the events that end their hold
+	Held time.Duration `json:"held,omitempty"`
+}
@@ -1,5 +1,9 @@
 package models
 
+import (
+	"time"
+)
+
 type Queue struct {
 	Resource     *Resource
 	Reservations []*Reservation
@@ -8,3 +12,114 @@ type Queue struct {
 func (q *Queue) HasReservations() bool {
 	return len(q.Reservations) > 0
 }
+
+// Holders returns the reservations of the users who currently hold the resource
+func (q *Queue) Holders() []*Reservation {
+	n := q.Resource.Holders()
+	if n > len(q.Reservations) {
+		n = len(q.Reservations)
+	}
+	return q.Reservations[:n]
+}
+
+// Waiting returns the reservations of the users who are waiting for the resource
+func (q *Queue) Waiting() []*Reservation {
+	return q.Reservations[len(q.Holders()):]
+}
+
+// Remove removes the reservation at idx and returns whether it was holding the resource. If it was, the
+// reservation that takes its place among the holders starts its hold at t.
+func (q *Queue) Remove(idx int, t time.Time) bool {
+	held := idx < q.Resource.Holders()
+	q.Reservations = append(q.Reservations[:idx], q.Reservations[idx+1:]...)
+	if held {
+		q.Promote(q.Resource.Holders()-1, t)
+	}
+	return held
+}
+
+// HeldFor returns how long the reservation at idx has been held at t, or zero if it is not held
+func (q *Queue) HeldFor(idx int, t time.Time) time.Duration {
+	if idx >= len(q.Holders()) || q.Reservations[idx].Pending() {
+		return 0
+	}
+	return t.Sub(q.Reservations[idx].Time)
+}
+
+// Promote starts the holds of the reservations from idx up to the resource's capacity at t. It is used
+// when reservations move up into the holders.
+func (q *Queue) Promote(idx int, t time.Time) {
+	for i := idx; i < len(q.Holders()); i++ {
+		q.Reservations[i].Time = t
+	}
+}
+
+// Find returns the index of the user's reservation, or -1 if they are not in the queue
+func (q *Queue) Find(u *User) int {
+	for i, res := range q.Reservations {
+		if res.User.ID == u.ID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Move moves the reservation at from to the index to. Reservations that become holders because of the move
+// start their holds at t.
+func (q *Queue) Move(from, to int, t time.Time) {
+	held := q.held()
+
+	moved := q.Reservations[from]
+	rest := append(append([]*Reservation{}, q.Reservations[:from]...), q.Reservations[from+1:]...)
+	if to < 0 {
+		to = 0
+	}
+	if to > len(rest) {
+		to = len(rest)
+	}
+	q.Reservations = append(append(append([]*Reservation{}, rest[:to]...), moved), rest[to:]...)
+	q.promoteNew(held, t)
+}
+
+// Swap swaps the reservations at i and j. Reservations that become holders because of the swap start their
+// holds at t.
+func (q *Queue) Swap(i, j int, t time.Time) {
+	held := q.held()
+	q.Reservations[i], q.Reservations[j] = q.Reservations[j], q.Reservations[i]
+	q.promoteNew(held, t)
+}
+
+// Handoff gives the reservation at idx to u, who starts holding at t. Any other reservation u has in the queue
+// is removed.
+func (q *Queue) Handoff(idx int, u *User, t time.Time) {
+	held := q.held()
+	q.Reservations[idx] = &Reservation{
+		User:     u,
+		Resource: q.Resource,
+		Time:     t,
+	}
+	for i, res := range q.Reservations {
+		if i != idx && res.User.ID == u.ID {
+			q.Reservations = append(q.Reservations[:i], q.Reservations[i+1:]...)
+			break
+		}
+	}
+	q.promoteNew(held, t)
+}
+
+func (q *Queue) held() map[*Reservation]bool {
+	ret := map[*Reservation]bool{}
+	for _, res := range q.Holders() {
+		ret[res] = true
+	}
+	return ret
+}
+
+// promoteNew starts the holds of holders that were not in held at t
+func (q *Queue) promoteNew(held map[*Reservation]bool, t time.Time) {
+	for _, res := range q.Holders() {
+		if !held[res] {
+			res.Time = t
+		}
+	}
+}
@@ -4,8 +4,75 @@ import (
 	"time"
 )
 
+// Priority orders the users waiting for a resource. Users are queued behind everyone waiting with the same
+// or a higher priority.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+var priorities = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+func (p Priority) String() string {
+	return priorities[p]
+}
+
+// ParsePriority returns the priority with the given name
+func ParsePriority(name string) (Priority, bool) {
+	for p, n := range priorities {
+		if n == name {
+			return p, true
+		}
+	}
+	return PriorityNormal, false
+}
+
 type Reservation struct {
-	User     *User
-	Resource *Resource
-	Time     time.Time
+	User     *User     `json:"user"`
+	Resource *Resource `json:"-"`
+	Time     time.Time `json:"time"`
+	// Pool is the name of the pool the reservation was made from, if any
+	Pool string `json:"pool,omitempty"`
+	// CreatedBy is the user who made the reservation on behalf of User, if it was someone else
+	CreatedBy *User `json:"created_by,omitempty"`
+	// Note is the reason for the reservation given by the user
+	Note string `json:"note,omitempty"`
+	// Priority is the priority the user was queued with
+	Priority Priority `json:"priority,omitempty"`
+	// Hold overrides the hold limit for this reservation when set
+	Hold time.Duration `json:"hold,omitempty"`
+	// Extensions is the number of times the holder has extended the reservation, adding Extended in total
+	Extensions int           `json:"extensions,omitempty"`
+	Extended   time.Duration `json:"extended,omitempty"`
+	// Reminded is set once the holder has been reminded that the reservation is about to expire
+	Reminded bool `json:"reminded,omitempty"`
+	// Nudges is the number of times the holder has been asked whether they still need the resource, most
+	// recently at Nudged
+	Nudges int       `json:"nudges,omitempty"`
+	Nudged time.Time `json:"nudged"`
+	// Composite holds the keys of every resource of the all-or-nothing request the reservation is part of,
+	// while the request is pending. A pending reservation keeps its place in line, and sets the resource
+	// aside once it gets to the front, but the user doesn't hold the resource until the request is granted.
+	Composite []string `json:"composite,omitempty"`
+}
+
+// Pending returns whether the reservation is part of an all-or-nothing request that hasn't been granted yet
+func (r *Reservation) Pending() bool {
+	return len(r.Composite) > 0
+}
+
+// Expires returns when the reservation expires given a hold limit. A zero time is returned if there is no limit
+// or the reservation is pending.
+func (r *Reservation) Expires(limit time.Duration) time.Time {
+	if limit <= 0 || r.Pending() {
+		return time.Time{}
+	}
+	return r.Time.Add(limit + r.Extended)
 }
@@ -9,6 +9,29 @@ type Resource struct {
 	Name         string
 	Env          string
 	LastActivity time.Time
+	// HoldLimit is the maximum amount of time the resource can be held. Zero means the env or global limit applies.
+	HoldLimit time.Duration `json:",omitempty"`
+	// MaxExtensions caps how many times a hold can be extended. Nil means there is no cap.
+	MaxExtensions *int `json:",omitempty"`
+	// Capacity is how many users can hold the resource at once. Zero means one.
+	Capacity int `json:",omitempty"`
+
+	Description string   `json:",omitempty"`
+	Team        string   `json:",omitempty"`
+	Links       []string `json:",omitempty"`
+	Tags        []string `json:",omitempty"`
+	// Watchers are notified when the resource becomes free or changes holder, without being in its queue
+	Watchers []*User `json:",omitempty"`
+}
+
+// IsWatchedBy returns whether the user is watching the resource
+func (r *Resource) IsWatchedBy(u *User) bool {
+	for _, w := range r.Watchers {
+		if w.ID == u.ID {
+			return true
+		}
+	}
+	return false
 }
 
 func ResourceKey(name, env string) string {
@@ -19,6 +42,24 @@ func (r *Resource) Key() string {
 	return ResourceKey(r.Name, r.Env)
 }
 
+// Holders returns how many users can hold the resource at once
+func (r *Resource) Holders() int {
+	if r.Capacity < 1 {
+		return 1
+	}
+	return r.Capacity
+}
+
+// HasTag returns whether the resource is tagged with tag
+func (r *Resource) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Resource) String() string {
 	if r.Env != "" {
 		return fmt.Sprintf("%s|%s", r.Env, r.Name)
@@ -22,10 +22,20 @@ var (
 	listenPort     int
 	debug          bool
 	admins         string
+	priorityUsers  string
+	envOwners      string
 	reqResourceEnv bool
 	pruneEnabled   bool
 	pruneInterval  int
 	pruneExpire    int
+	store          string
+	storePath      string
+	storeDSN       string
+	holdLimit      time.Duration
+	envHoldLimits  string
+	holdReminder   time.Duration
+	nudgeAfter     time.Duration
+	poolList       string
 )
 
 func main() {
@@ -34,10 +44,20 @@ func main() {
 	flag.IntVar(&listenPort, "listen-port", util.LookupEnvOrInt("LISTEN_PORT", 666), "Listen port")
 	flag.BoolVar(&debug, "debug", util.LookupEnvOrBool("DEBUG", false), "Debug mode")
 	flag.StringVar(&admins, "admins", util.LookupEnvOrString("SLACK_ADMINS", ""), "Turn on administrative commands for specific admins, comma separated list")
+	flag.StringVar(&priorityUsers, "priority-users", util.LookupEnvOrString("PRIORITY_USERS", ""), "Users other than admins who can reserve with high priority, comma separated list")
+	flag.StringVar(&envOwners, "env-owners", util.LookupEnvOrString("ENV_OWNERS", ""), "Users other than admins who can reserve on behalf of others in an env, comma separated list of env=user")
 	flag.BoolVar(&reqResourceEnv, "require-resource-env", util.LookupEnvOrBool("REQUIRE_RESOURCE_ENV", true), "Require resource reservation to include environment")
 	flag.BoolVar(&pruneEnabled, "prune-enabled", util.LookupEnvOrBool("PRUNE_ENABLED", true), "Enable pruning available resources automatically")
 	flag.IntVar(&pruneInterval, "prune-interval", util.LookupEnvOrInt("PRUNE_INTERVAL", 1), "Automatic pruning interval in hours")
 	flag.IntVar(&pruneExpire, "prune-expire", util.LookupEnvOrInt("PRUNE_EXPIRE", 168), "Automatic prune expiration time in hours")
+	flag.StringVar(&store, "store", util.LookupEnvOrString("STORE", "memory"), "Storage backend for reservations: memory, file, bolt, sql or redis")
+	flag.StringVar(&storePath, "store-path", util.LookupEnvOrString("STORE_PATH", ""), "Path to the state file when using the file or bolt store")
+	flag.StringVar(&storeDSN, "store-dsn", util.LookupEnvOrString("STORE_DSN", ""), "Database DSN when using the sql or redis store. For sql, a file path uses SQLite and a postgres:// URL uses Postgres")
+	flag.DurationVar(&holdLimit, "hold-limit", util.LookupEnvOrDuration("HOLD_LIMIT", 0), "Maximum time a resource can be held before it is released automatically, e.g. 8h. Zero means no limit")
+	flag.StringVar(&envHoldLimits, "env-hold-limits", util.LookupEnvOrString("ENV_HOLD_LIMITS", ""), "Hold limits for specific envs, comma separated list of env=duration")
+	flag.DurationVar(&holdReminder, "hold-reminder", util.LookupEnvOrDuration("HOLD_REMINDER", 15*time.Minute), "How long before a hold expires its holder is reminded and offered an extension. Zero disables reminders")
+	flag.DurationVar(&nudgeAfter, "nudge-after", util.LookupEnvOrDuration("NUDGE_AFTER", 0), "How long a resource can be held while others are waiting before its holder is asked whether they still need it, e.g. 4h. Zero disables nudges")
+	flag.StringVar(&poolList, "pools", util.LookupEnvOrString("POOLS", ""), "Pools of interchangeable resources, comma separated list of name=pattern, e.g. qa-web=qa|web*")
 	flag.Parse()
 
 	// Make sure required vars are set
@@ -50,9 +70,38 @@ func main() {
 		return
 	}
 
+	envLimits, err := util.ParseDurations(envHoldLimits)
+	if err != nil {
+		log.Errorf("Invalid env hold limits: %+v", err)
+		return
+	}
+
+	pools, err := util.ParsePools(poolList)
+	if err != nil {
+		log.Errorf("Invalid pools: %+v", err)
+		return
+	}
+
+	owners, err := util.ParseOwners(envOwners)
+	if err != nil {
+		log.Errorf("Invalid env owners: %+v", err)
+		return
+	}
+
 	api := slack.New(token, slack.OptionDebug(debug))
 
-	data := data.NewMemory()
+	data, err := newStore()
+	if err != nil {
+		log.Errorf("Unable to open %s store: %+v", store, err)
+		return
+	}
+
+	handler := handler.New(api, data, reqResourceEnv, util.ParseAdmins(admins), util.ParseAdmins(priorityUsers), owners, handler.Limits{
+		Hold:    holdLimit,
+		EnvHold: envLimits,
+		Remind:  holdReminder,
+		Nudge:   nudgeAfter,
+	}, pools)
 
 	if pruneEnabled {
 		// Prune inactive resources
@@ -60,7 +109,7 @@ func main() {
 		go func() {
 			for {
 				time.Sleep(time.Duration(pruneInterval) * time.Hour)
-				err := data.PruneInactiveResources(pruneExpire)
+				err := handler.Prune(pruneExpire)
 				if err != nil {
 					log.Errorf("Error pruning resources: %+v", err)
 				} else {
@@ -73,7 +122,17 @@ func main() {
 		log.Infof("Automatic pruning is disabled.")
 	}
 
-	handler := handler.New(api, data, reqResourceEnv, util.ParseAdmins(admins))
+	// Remind holders before their holds expire, release reservations that have been held for longer than
+	// their hold limit and nudge holders that others are waiting on
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			handler.RemindExpiring()
+			handler.ReleaseExpired()
+			handler.Settle()
+			handler.NudgeIdleHolders()
+		}
+	}()
 
 	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
 		buf := new(bytes.Buffer)
@@ -105,12 +164,64 @@ func main() {
 			w.Header().Set("Content-Type", "text")
 			w.Write([]byte(r.Challenge))
 		case slackevents.CallbackEvent:
-			err := handler.CallbackEvent(eventsAPIEvent)
+			// Slack retries events that aren't acknowledged within 3 seconds, so they are handled after the
+			// response is sent
+			w.WriteHeader(http.StatusOK)
+			go func() {
+				err := handler.CallbackEvent(eventsAPIEvent)
+				if err != nil {
+					log.Errorf("%+v", err)
+				}
+			}()
+		default:
+		}
+	})
+
+	http.HandleFunc("/commands", func(w http.ResponseWriter, r *http.Request) {
+		cmd, err := slack.SlashCommandParse(r)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Errorf("%+v", err)
+			return
+		}
+		if !cmd.ValidateToken(challenge) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		api.Debugf("Command: %s %s", cmd.Command, cmd.Text)
+
+		// Slack expects an answer within 3 seconds, so replies are sent to the command's response URL instead
+		go func() {
+			err := handler.SlashCommand(cmd)
 			if err != nil {
 				log.Errorf("%+v", err)
 			}
-		default:
+		}()
+	})
+
+	http.HandleFunc("/interactions", func(w http.ResponseWriter, r *http.Request) {
+		var cb slack.InteractionCallback
+		err := json.Unmarshal([]byte(r.FormValue("payload")), &cb)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Errorf("%+v", err)
+			return
+		}
+		if cb.Token != challenge {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
 		}
+
+		api.Debugf("Interaction: %s", r.FormValue("payload"))
+
+		// As with slash commands, replies are sent to the response URL
+		go func() {
+			err := handler.Interaction(cb)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		}()
 	})
 
 	log.Infof("Server listening on port %d", listenPort)
@@ -118,3 +229,38 @@ func main() {
 	http.ListenAndServe(fmt.Sprintf(":%v", listenPort), nil)
 
 }
+
+// newStore returns the data manager selected by the -store flag
+func newStore() (data.Manager, error) {
+	switch store {
+	case "memory":
+		return data.NewMemory(), nil
+	case "file":
+		path := storePathOrDefault("reservebot.json")
+		log.Infof("Persisting reservations to %s", path)
+		return data.NewFile(path)
+	case "bolt":
+		path := storePathOrDefault("reservebot.db")
+		log.Infof("Persisting reservations to %s", path)
+		return data.NewBolt(path)
+	case "sql":
+		if storeDSN == "" {
+			return nil, fmt.Errorf("a DSN is required for the sql store")
+		}
+		return data.NewSQL(storeDSN)
+	case "redis":
+		if storeDSN == "" {
+			return nil, fmt.Errorf("a redis:// DSN is required for the redis store")
+		}
+		return data.NewRedis(storeDSN)
+	default:
+		return nil, fmt.Errorf("unknown store %q", store)
+	}
+}
+
+func storePathOrDefault(def string) string {
+	if storePath == "" {
+		return def
+	}
+	return storePath
+}
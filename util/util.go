@@ -1,10 +1,12 @@
 package util
 
 import (
+	"fmt"
 	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func Ordinalize(num int) string {
@@ -60,6 +62,68 @@ func LookupEnvOrBool(key string, defaultVal bool) bool {
 	return defaultVal
 }
 
+func LookupEnvOrDuration(key string, defaultVal time.Duration) time.Duration {
+	if val, ok := os.LookupEnv(key); ok {
+		v, _ := time.ParseDuration(val)
+		return v
+	}
+	return defaultVal
+}
+
+// ParseDurations parses a comma separated list of key=duration pairs, e.g. "qa=2h,staging=30m"
+func ParseDurations(list string) (map[string]time.Duration, error) {
+	ret := map[string]time.Duration{}
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid duration %q, expected key=duration", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		ret[strings.TrimSpace(kv[0])] = d
+	}
+	return ret, nil
+}
+
+// ParsePools parses a comma separated list of name=pattern pairs, e.g. "qa-web=qa|web*,gpu=qa|gpu*"
+func ParsePools(list string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid pool %q, expected name=pattern", pair)
+		}
+		ret[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return ret, nil
+}
+
+// ParseOwners parses a comma separated list of env=user pairs, e.g. "qa=amelia,qa=sam,staging=kim". An env
+// can be listed more than once to give it several owners.
+func ParseOwners(list string) (map[string][]string, error) {
+	ret := map[string][]string{}
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid owner %q, expected env=user", pair)
+		}
+		env := strings.TrimSpace(kv[0])
+		ret[env] = append(ret[env], strings.TrimSpace(kv[1]))
+	}
+	return ret, nil
+}
+
 func ParseAdmins(admins string) []string {
 	// Convert admins list into slice
 	var admins_ary []string